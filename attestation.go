@@ -0,0 +1,106 @@
+package tinfoil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/openai/openai-go/v3/option"
+)
+
+// AttestationMode selects when a Client performs its initial attestation.
+type AttestationMode int
+
+const (
+	// AttestEager attests before the constructor returns, failing it if
+	// attestation fails. This is the default.
+	AttestEager AttestationMode = iota
+	// AttestLazy attests on the first request. Concurrent first requests
+	// share a single attestation.
+	AttestLazy
+	// AttestBackground starts attesting when the client is created without
+	// waiting for it. Requests block until attestation completes.
+	AttestBackground
+)
+
+func (m AttestationMode) String() string {
+	switch m {
+	case AttestEager:
+		return "eager"
+	case AttestLazy:
+		return "lazy"
+	case AttestBackground:
+		return "background"
+	}
+	return fmt.Sprintf("AttestationMode(%d)", int(m))
+}
+
+// WithAttestation returns an option that sets ClientOptions.Attestation when
+// passed to a Client constructor, such as NewClient.
+func WithAttestation(mode AttestationMode) option.RequestOption {
+	return newClientOption(func(opts *ClientOptions) { opts.Attestation = mode })
+}
+
+// pendingAttestation is an initial attestation in flight. err is set before
+// done is closed.
+type pendingAttestation struct {
+	done    chan struct{}
+	err     error
+	joined  atomic.Int32 // requests that waited on it, for tests
+}
+
+// ready returns the pinned transport and the attestation it is pinned to,
+// performing or joining the initial attestation if it has not succeeded yet.
+// A failed initial attestation is returned to every request waiting on it,
+// and the next request tries again.
+func (t *reVerifyingTransport) ready(ctx context.Context) (http.RoundTripper, AttestationStatus, error) {
+	t.mu.RLock()
+	transport, status := t.pinnedLocked()
+	t.mu.RUnlock()
+	if transport != nil {
+		return transport, status, nil
+	}
+
+	p := t.startAttestation()
+	p.joined.Add(1)
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return nil, AttestationStatus{}, ctx.Err()
+	}
+	if p.err != nil {
+		return nil, AttestationStatus{}, fmt.Errorf("failed to attest enclave: %w", p.err)
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	transport, status = t.pinnedLocked()
+	return transport, status, nil
+}
+
+// startAttestation starts the initial attestation unless one is already in
+// flight, and returns it.
+func (t *reVerifyingTransport) startAttestation() *pendingAttestation {
+	t.initMu.Lock()
+	defer t.initMu.Unlock()
+	if t.pending != nil {
+		return t.pending
+	}
+
+	p := &pendingAttestation{done: make(chan struct{})}
+	t.pending = p
+	go func() {
+		_, _, err := t.reverify()
+
+		t.initMu.Lock()
+		p.err = err
+		// Forget a failed attempt so the next request tries again
+		if err != nil {
+			t.pending = nil
+		}
+		t.initMu.Unlock()
+		close(p.done)
+	}()
+	return p
+}
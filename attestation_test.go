@@ -0,0 +1,160 @@
+package tinfoil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tinfoilsh/verifier/client"
+)
+
+// gatedVerifier blocks every Verify until gate is closed, failing with the
+// error taken from errs if there is one.
+type gatedVerifier struct {
+	gate          chan struct{}
+	errs          chan error
+	verifications atomic.Int32
+}
+
+func newGatedVerifier() *gatedVerifier {
+	return &gatedVerifier{gate: make(chan struct{}), errs: make(chan error, 1)}
+}
+
+func (v *gatedVerifier) Enclave() string { return "enclave.example.com" }
+func (v *gatedVerifier) Repo() string    { return "tinfoilsh/example" }
+
+func (v *gatedVerifier) Verify() (*client.GroundTruth, error) {
+	v.verifications.Add(1)
+	<-v.gate
+	select {
+	case err := <-v.errs:
+		return nil, err
+	default:
+		return &client.GroundTruth{Digest: "a"}, nil
+	}
+}
+
+func (v *gatedVerifier) Transport() (http.RoundTripper, error) {
+	return roundTripFunc(okTransport), nil
+}
+
+// joinedAttestation returns the number of requests that joined the pending
+// initial attestation of c.
+func joinedAttestation(c *Client) int32 {
+	c.transport.initMu.Lock()
+	defer c.transport.initMu.Unlock()
+	if c.transport.pending == nil {
+		return 0
+	}
+	return c.transport.pending.joined.Load()
+}
+
+// getConcurrently makes n requests at once and returns their errors.
+func getConcurrently(c *Client, n int) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			resp, err := c.HTTPClient().Get("https://enclave.example.com/health")
+			if err == nil {
+				resp.Body.Close()
+			}
+			errs[i] = err
+		})
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestAttestLazy(t *testing.T) {
+	v := newGatedVerifier()
+	c, err := NewClientWithOptions(v, ClientOptions{Attestation: AttestLazy})
+	require.NoError(t, err)
+	require.Zero(t, v.verifications.Load())
+	require.Nil(t, c.GroundTruth())
+	require.Zero(t, c.Attestation().Generation)
+
+	close(v.gate)
+	for _, err := range getConcurrently(c, 10) {
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(1), v.verifications.Load())
+	require.Equal(t, "a", c.GroundTruth().Digest)
+	require.Equal(t, uint64(1), c.Attestation().Generation)
+}
+
+func TestAttestLazyError(t *testing.T) {
+	verifyErr := errors.New("attestation rejected")
+	v := newGatedVerifier()
+	v.errs <- verifyErr
+	c, err := NewClientWithOptions(v, ClientOptions{Attestation: AttestLazy})
+	require.NoError(t, err)
+
+	// Every request sharing the failed attestation reports it
+	errs := make(chan []error)
+	go func() { errs <- getConcurrently(c, 5) }()
+	// A request joining after the failure would start a new attestation
+	require.Eventually(t, func() bool { return joinedAttestation(c) == 5 }, time.Second, time.Millisecond)
+	close(v.gate)
+	for _, err := range <-errs {
+		require.ErrorIs(t, err, verifyErr)
+	}
+	require.ErrorIs(t, c.AttestationErr(), verifyErr)
+
+	// The next request attests again
+	resp, err := c.HTTPClient().Get("https://enclave.example.com/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, int32(2), v.verifications.Load())
+	require.NoError(t, c.AttestationErr())
+}
+
+func TestAttestBackground(t *testing.T) {
+	v := newGatedVerifier()
+	c, err := NewClientWithOptions(v, ClientOptions{Attestation: AttestBackground})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return v.verifications.Load() == 1 }, time.Second, time.Millisecond)
+
+	// Requests block until the background attestation completes
+	done := make(chan error)
+	go func() { done <- getConcurrently(c, 1)[0] }()
+	require.Eventually(t, func() bool { return joinedAttestation(c) == 1 }, time.Second, time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("request completed before attestation")
+	default:
+	}
+
+	close(v.gate)
+	require.NoError(t, <-done)
+	require.NoError(t, c.WaitForAttestation(context.Background()))
+	require.Equal(t, int32(1), v.verifications.Load())
+}
+
+func TestWaitForAttestationCanceled(t *testing.T) {
+	v := newGatedVerifier()
+	defer close(v.gate)
+	c, err := NewClientWithOptions(v, ClientOptions{Attestation: AttestLazy})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, c.WaitForAttestation(ctx), context.DeadlineExceeded)
+	require.Equal(t, int32(1), v.verifications.Load())
+}
+
+func TestAttestEager(t *testing.T) {
+	v := &fakeVerifier{transports: []http.RoundTripper{roundTripFunc(okTransport)}}
+	c, err := NewClientWithOptions(v, ClientOptions{Attestation: AttestEager})
+	require.NoError(t, err)
+	require.Equal(t, 1, v.verifications)
+	require.NoError(t, c.WaitForAttestation(context.Background()))
+
+	_, err = NewClientWithOptions(v, ClientOptions{Attestation: AttestationMode(7)})
+	require.ErrorContains(t, err, "unknown attestation mode")
+}
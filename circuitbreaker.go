@@ -0,0 +1,332 @@
+package tinfoil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3/option"
+)
+
+// CircuitState is the state of a Client's circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through while counting failures.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails requests fast with a *CircuitOpenError.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through to
+	// decide whether to close the circuit again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// ErrCircuitOpen is matched by errors.Is for every *CircuitOpenError.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is returned without contacting the enclave while its
+// circuit breaker is open, or half-open with all probes in flight.
+type CircuitOpenError struct {
+	Enclave string
+	State   CircuitState
+	RetryAt time.Time // Earliest time the circuit lets probe requests through
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %s is %s, retry after %s", e.Enclave, e.State, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// CircuitStateChange describes a transition of a circuit breaker.
+type CircuitStateChange struct {
+	Enclave  string
+	From, To CircuitState
+	At       time.Time
+}
+
+// CircuitBreaker configures a circuit breaker around a Client's transport.
+// Zero fields select the defaults.
+type CircuitBreaker struct {
+	// FailureRate opens the circuit once this fraction of the requests in
+	// Window failed, counting only windows with at least MinRequests
+	// requests. They default to 0.5, one minute and 10.
+	FailureRate float64
+	Window      time.Duration
+	MinRequests int
+
+	// OpenTimeout is how long the circuit stays open before half-opening.
+	// Defaults to 30s.
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of probe requests let through while
+	// half-open, all of which must succeed to close the circuit. A failed
+	// probe opens it again. Defaults to 1.
+	HalfOpenProbes int
+
+	// IsFailure classifies the outcome of a request. Defaults to transport
+	// errors, including timeouts but not cancellation of the request, and
+	// 5xx statuses. A transport error it does not count as a failure is
+	// ignored rather than counted as a success.
+	IsFailure func(req *http.Request, resp *http.Response, err error) bool
+
+	// OnStateChange is called after every state transition, outside of the
+	// breaker's lock.
+	OnStateChange func(CircuitStateChange)
+}
+
+// WithCircuitBreaker returns an option that sets ClientOptions.CircuitBreaker
+// when passed to a Client constructor, such as NewClient.
+func WithCircuitBreaker(breaker CircuitBreaker) option.RequestOption {
+	return newClientOption(func(opts *ClientOptions) { opts.CircuitBreaker = &breaker })
+}
+
+// Validate reports whether the configuration is usable.
+func (b CircuitBreaker) Validate() error {
+	switch {
+	case b.FailureRate < 0 || b.FailureRate > 1:
+		return fmt.Errorf("invalid circuit breaker: failure rate must be between 0 and 1, got %g", b.FailureRate)
+	case b.Window < 0 || b.OpenTimeout < 0:
+		return fmt.Errorf("invalid circuit breaker: durations must not be negative")
+	case b.MinRequests < 0 || b.HalfOpenProbes < 0:
+		return fmt.Errorf("invalid circuit breaker: request counts must not be negative")
+	}
+	return nil
+}
+
+func (b CircuitBreaker) withDefaults() CircuitBreaker {
+	if b.FailureRate == 0 {
+		b.FailureRate = 0.5
+	}
+	if b.Window == 0 {
+		b.Window = time.Minute
+	}
+	if b.MinRequests == 0 {
+		b.MinRequests = 10
+	}
+	if b.OpenTimeout == 0 {
+		b.OpenTimeout = 30 * time.Second
+	}
+	if b.HalfOpenProbes == 0 {
+		b.HalfOpenProbes = 1
+	}
+	if b.IsFailure == nil {
+		b.IsFailure = isCircuitFailure
+	}
+	return b
+}
+
+// isCircuitFailure is the default CircuitBreaker.IsFailure.
+func isCircuitFailure(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(req.Context().Err(), context.Canceled)
+	}
+	return resp.StatusCode >= 500
+}
+
+// circuitOutcome is the outcome of a request let through by the breaker.
+type circuitOutcome int
+
+const (
+	circuitPass circuitOutcome = iota
+	circuitFail
+	// circuitNeutral is neither, such as a canceled request. It frees a
+	// probe slot without closing or opening the circuit.
+	circuitNeutral
+)
+
+// circuitBuckets is the number of buckets the rolling window is split into.
+const circuitBuckets = 10
+
+type circuitBucket struct {
+	start           time.Time
+	total, failures int
+}
+
+// circuitBreaker implements CircuitBreaker for one enclave.
+type circuitBreaker struct {
+	config  CircuitBreaker
+	enclave func() string // the enclave may only be known once attested
+
+	mu       sync.Mutex
+	state    CircuitState
+	openedAt time.Time
+	buckets  [circuitBuckets]circuitBucket
+	probes   int // probes in flight while half-open
+	passed   int // successful probes while half-open
+}
+
+func newCircuitBreaker(config CircuitBreaker, enclave func() string) *circuitBreaker {
+	return &circuitBreaker{config: config.withDefaults(), enclave: enclave}
+}
+
+// State returns the current state, half-opening an open circuit whose
+// timeout has passed.
+func (b *circuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	change := b.advance(time.Now())
+	state := b.state
+	b.mu.Unlock()
+
+	b.notify(change)
+	return state
+}
+
+// allow reports whether a request may proceed. A half-open circuit counts
+// the request as a probe.
+func (b *circuitBreaker) allow(now time.Time) (probe bool, err error) {
+	b.mu.Lock()
+	change := b.advance(now)
+	switch b.state {
+	case CircuitOpen:
+		err = b.openError()
+	case CircuitHalfOpen:
+		if b.probes+b.passed >= b.config.HalfOpenProbes {
+			err = b.openError()
+		} else {
+			b.probes++
+			probe = true
+		}
+	}
+	b.mu.Unlock()
+
+	b.notify(change)
+	return probe, err
+}
+
+// record counts the outcome of an allowed request.
+func (b *circuitBreaker) record(probe bool, outcome circuitOutcome, now time.Time) {
+	b.mu.Lock()
+	var change *CircuitStateChange
+	switch {
+	case probe:
+		b.probes--
+		// A probe outcome only counts while still half-open
+		if b.state != CircuitHalfOpen {
+			break
+		}
+		switch outcome {
+		case circuitFail:
+			change = b.transition(CircuitOpen, now)
+		case circuitPass:
+			if b.passed++; b.passed >= b.config.HalfOpenProbes {
+				change = b.transition(CircuitClosed, now)
+			}
+		}
+	case b.state == CircuitClosed && outcome != circuitNeutral:
+		bucket := b.bucket(now)
+		bucket.total++
+		if outcome == circuitFail {
+			bucket.failures++
+			if b.tripped(now) {
+				change = b.transition(CircuitOpen, now)
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	b.notify(change)
+}
+
+// bucket returns the window bucket for now, resetting it if it is stale.
+func (b *circuitBreaker) bucket(now time.Time) *circuitBucket {
+	width := b.config.Window / circuitBuckets
+	start := now.Truncate(max(width, 1))
+	bucket := &b.buckets[start.UnixNano()/int64(max(width, 1))%circuitBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = circuitBucket{start: start}
+	}
+	return bucket
+}
+
+// tripped reports whether the failures in the window open the circuit.
+func (b *circuitBreaker) tripped(now time.Time) bool {
+	var total, failures int
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.config.Window {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	return total >= b.config.MinRequests && float64(failures) >= b.config.FailureRate*float64(total)
+}
+
+// advance half-opens the circuit once the open timeout has passed.
+func (b *circuitBreaker) advance(now time.Time) *CircuitStateChange {
+	if b.state == CircuitOpen && now.Sub(b.openedAt) >= b.config.OpenTimeout {
+		return b.transition(CircuitHalfOpen, now)
+	}
+	return nil
+}
+
+// transition moves to state, resetting the counters it starts from.
+func (b *circuitBreaker) transition(state CircuitState, now time.Time) *CircuitStateChange {
+	change := &CircuitStateChange{Enclave: b.enclave(), From: b.state, To: state, At: now}
+	b.state = state
+	b.passed = 0
+	switch state {
+	case CircuitOpen:
+		b.openedAt = now
+	case CircuitClosed:
+		b.buckets = [circuitBuckets]circuitBucket{}
+	}
+	return change
+}
+
+func (b *circuitBreaker) openError() *CircuitOpenError {
+	return &CircuitOpenError{
+		Enclave: b.enclave(),
+		State:   b.state,
+		RetryAt: b.openedAt.Add(b.config.OpenTimeout),
+	}
+}
+
+func (b *circuitBreaker) notify(change *CircuitStateChange) {
+	if change != nil && b.config.OnStateChange != nil {
+		b.config.OnStateChange(*change)
+	}
+}
+
+// circuitBreakerTransport fails requests fast while the breaker is open.
+type circuitBreakerTransport struct {
+	breaker *circuitBreaker
+	next    http.RoundTripper
+}
+
+func (t *circuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	probe, err := t.breaker.allow(time.Now())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	outcome := circuitPass
+	switch {
+	case t.breaker.config.IsFailure(req, resp, err):
+		outcome = circuitFail
+	case err != nil:
+		outcome = circuitNeutral
+	}
+	t.breaker.record(probe, outcome, time.Now())
+	return resp, err
+}
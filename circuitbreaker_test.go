@@ -0,0 +1,232 @@
+package tinfoil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testEnclave() string { return "enclave.example.com" }
+
+func TestCircuitBreaker(t *testing.T) {
+	var changes []CircuitStateChange
+	b := newCircuitBreaker(CircuitBreaker{
+		MinRequests:   4,
+		OpenTimeout:   time.Minute,
+		OnStateChange: func(c CircuitStateChange) { changes = append(changes, c) },
+	}, testEnclave)
+	now := time.Now()
+
+	// Below MinRequests the failure rate is not evaluated
+	for _, outcome := range []circuitOutcome{circuitPass, circuitFail, circuitFail} {
+		_, err := b.allow(now)
+		require.NoError(t, err)
+		b.record(false, outcome, now)
+	}
+	require.Equal(t, CircuitClosed, b.state)
+
+	_, err := b.allow(now)
+	require.NoError(t, err)
+	b.record(false, circuitFail, now)
+	require.Equal(t, CircuitOpen, b.state)
+
+	_, err = b.allow(now.Add(time.Second))
+	var openErr *CircuitOpenError
+	require.ErrorAs(t, err, &openErr)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, "enclave.example.com", openErr.Enclave)
+	require.Equal(t, now.Add(time.Minute), openErr.RetryAt)
+
+	// After the timeout a single probe is let through
+	later := now.Add(time.Minute)
+	probe, err := b.allow(later)
+	require.NoError(t, err)
+	require.True(t, probe)
+	_, err = b.allow(later)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	// A failed probe opens the circuit again
+	b.record(true, circuitFail, later)
+	require.Equal(t, CircuitOpen, b.state)
+
+	// A successful one closes it
+	later = later.Add(time.Minute)
+	probe, err = b.allow(later)
+	require.NoError(t, err)
+	b.record(probe, circuitPass, later)
+	require.Equal(t, CircuitClosed, b.state)
+
+	var transitions [][2]CircuitState
+	for _, c := range changes {
+		require.Equal(t, "enclave.example.com", c.Enclave)
+		transitions = append(transitions, [2]CircuitState{c.From, c.To})
+	}
+	require.Equal(t, [][2]CircuitState{
+		{CircuitClosed, CircuitOpen},
+		{CircuitOpen, CircuitHalfOpen},
+		{CircuitHalfOpen, CircuitOpen},
+		{CircuitOpen, CircuitHalfOpen},
+		{CircuitHalfOpen, CircuitClosed},
+	}, transitions)
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	b := newCircuitBreaker(CircuitBreaker{MinRequests: 2, Window: 10 * time.Second}, testEnclave)
+	now := time.Now()
+
+	b.record(false, circuitFail, now)
+	// The first failure has left the window
+	b.record(false, circuitFail, now.Add(15*time.Second))
+	require.Equal(t, CircuitClosed, b.state)
+
+	b.record(false, circuitFail, now.Add(16*time.Second))
+	require.Equal(t, CircuitOpen, b.state)
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	b := newCircuitBreaker(CircuitBreaker{MinRequests: 1, HalfOpenProbes: 2, OpenTimeout: time.Second}, testEnclave)
+	now := time.Now()
+
+	b.record(false, circuitFail, now)
+	require.Equal(t, CircuitOpen, b.state)
+
+	later := now.Add(time.Second)
+	for range 2 {
+		probe, err := b.allow(later)
+		require.NoError(t, err)
+		require.True(t, probe)
+	}
+	_, err := b.allow(later)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	// Every probe must succeed before the circuit closes
+	b.record(true, circuitPass, later)
+	require.Equal(t, CircuitHalfOpen, b.state)
+	b.record(true, circuitPass, later)
+	require.Equal(t, CircuitClosed, b.state)
+}
+
+func TestCircuitBreakerTimeouts(t *testing.T) {
+	hanging := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	transport := &circuitBreakerTransport{
+		breaker: newCircuitBreaker(CircuitBreaker{MinRequests: 1, OpenTimeout: 20 * time.Millisecond}, testEnclave),
+		next:    hanging,
+	}
+	send := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://enclave.example.com/health", nil)
+		require.NoError(t, err)
+		_, err = transport.RoundTrip(req)
+		return err
+	}
+	sendWithTimeout := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+		return send(ctx)
+	}
+
+	// A request that times out is a failure
+	require.ErrorIs(t, sendWithTimeout(), context.DeadlineExceeded)
+	require.Equal(t, CircuitOpen, transport.breaker.State())
+
+	// So is a probe, which opens the circuit again
+	require.Eventually(t, func() bool { return transport.breaker.State() == CircuitHalfOpen }, time.Second, time.Millisecond)
+	require.ErrorIs(t, sendWithTimeout(), context.DeadlineExceeded)
+	require.Equal(t, CircuitOpen, transport.breaker.State())
+
+	// A canceled probe neither closes nor opens the circuit, but frees its slot
+	require.Eventually(t, func() bool { return transport.breaker.State() == CircuitHalfOpen }, time.Second, time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, send(ctx), context.Canceled)
+	require.Equal(t, CircuitHalfOpen, transport.breaker.State())
+	probe, err := transport.breaker.allow(time.Now())
+	require.NoError(t, err)
+	require.True(t, probe)
+}
+
+func TestCircuitBreakerNeutralOutcome(t *testing.T) {
+	b := newCircuitBreaker(CircuitBreaker{MinRequests: 1, OpenTimeout: time.Second}, testEnclave)
+	now := time.Now()
+
+	// Neutral outcomes are not counted while closed
+	b.record(false, circuitNeutral, now)
+	require.Equal(t, CircuitClosed, b.state)
+	require.False(t, b.tripped(now))
+
+	b.record(false, circuitFail, now)
+	require.Equal(t, CircuitOpen, b.state)
+
+	later := now.Add(time.Second)
+	probe, err := b.allow(later)
+	require.NoError(t, err)
+	b.record(probe, circuitNeutral, later)
+	require.Equal(t, CircuitHalfOpen, b.state)
+	require.Zero(t, b.probes)
+	require.Zero(t, b.passed)
+}
+
+func TestCircuitBreakerValidate(t *testing.T) {
+	require.NoError(t, CircuitBreaker{}.Validate())
+	require.Error(t, CircuitBreaker{FailureRate: 1.5}.Validate())
+	require.Error(t, CircuitBreaker{OpenTimeout: -time.Second}.Validate())
+
+	_, err := NewClientWithOptions(&fakeVerifier{}, ClientOptions{CircuitBreaker: &CircuitBreaker{MinRequests: -1}})
+	require.Error(t, err)
+	_, err = NewClientWithVerifier(&fakeVerifier{}, WithCircuitBreaker(CircuitBreaker{HalfOpenProbes: -1}))
+	require.Error(t, err)
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var attempts int
+	failing := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return statusResponse(req, http.StatusServiceUnavailable, nil), nil
+	})
+
+	var opened bool
+	c, err := NewClientWithOptions(&fakeVerifier{transports: []http.RoundTripper{failing}}, ClientOptions{
+		Retry: &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		CircuitBreaker: &CircuitBreaker{
+			MinRequests:   2,
+			OpenTimeout:   time.Hour,
+			OnStateChange: func(c CircuitStateChange) { opened = c.To == CircuitOpen },
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, CircuitClosed, c.CircuitState())
+
+	// The retries trip the breaker, and the open circuit is not retried
+	_, err = c.HTTPClient().Get("https://enclave.example.com/health")
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, 2, attempts)
+	require.True(t, opened)
+	require.Equal(t, CircuitOpen, c.CircuitState())
+
+	// Further requests fail fast
+	_, err = c.HTTPClient().Get("https://enclave.example.com/health")
+	var openErr *CircuitOpenError
+	require.True(t, errors.As(err, &openErr))
+	require.Equal(t, 2, attempts)
+}
+
+func TestClientCircuitBreakerOption(t *testing.T) {
+	failing := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return statusResponse(req, http.StatusServiceUnavailable, nil), nil
+	})
+
+	c, err := NewClientWithVerifier(&fakeVerifier{transports: []http.RoundTripper{failing}},
+		WithCircuitBreaker(CircuitBreaker{MinRequests: 1, OpenTimeout: time.Hour}))
+	require.NoError(t, err)
+
+	resp, err := c.HTTPClient().Get("https://enclave.example.com/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, CircuitOpen, c.CircuitState())
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/tinfoilsh/tinfoil-go"
+)
+
+const chatHelp = `Commands:
+  /model [name]      Show or change the model
+  /system [prompt]   Show or change the system prompt
+  /search [on|off]   Show, toggle or set web search
+  /reset             Clear the conversation history
+  /save <path>       Save the conversation as JSON
+  /attestation       Show the verified enclave attestation
+  /help              Show this help
+  /quit              Exit
+`
+
+// chatMessage is a single turn of the conversation, kept in a form that can
+// be saved and replayed independently of the openai-go param types.
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatTranscript is the file format written by /save.
+type chatTranscript struct {
+	Enclave  string        `json:"enclave"`
+	Digest   string        `json:"digest,omitempty"`
+	Model    string        `json:"model"`
+	System   string        `json:"system,omitempty"`
+	Messages []chatMessage `json:"messages"`
+}
+
+// chatSession holds the state of an interactive chat REPL.
+type chatSession struct {
+	client    *tinfoil.Client
+	out       io.Writer
+	model     string
+	system    string
+	webSearch bool
+	history   []chatMessage
+}
+
+func runChat(args []string) error {
+	fs := flag.NewFlagSet("chat", flag.ContinueOnError)
+	var cf clientFlags
+	cf.register(fs)
+	model := fs.String("model", "llama3-3-70b", "model to chat with")
+	system := fs.String("system", "", "initial system prompt")
+	webSearch := fs.Bool("web-search", false, "enable web search for each turn")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := cf.newClient()
+	if err != nil {
+		return err
+	}
+
+	s := &chatSession{
+		client:    c,
+		out:       os.Stdout,
+		model:     *model,
+		system:    *system,
+		webSearch: *webSearch,
+	}
+	return s.run(os.Stdin)
+}
+
+// run reads prompts and slash-commands from in until EOF or /quit.
+func (s *chatSession) run(in io.Reader) error {
+	fmt.Fprintln(s.out, s.statusLine())
+	fmt.Fprintln(s.out, `Type a message, or /help for commands.`)
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for {
+		fmt.Fprint(s.out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(s.out)
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "/") {
+			quit, err := s.handleCommand(line)
+			if err != nil {
+				fmt.Fprintf(s.out, "error: %v\n", err)
+			}
+			if quit {
+				return nil
+			}
+			continue
+		}
+
+		// Ctrl-C interrupts the current response rather than the whole session
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err := s.send(ctx, line)
+		stop()
+		if err != nil {
+			fmt.Fprintf(s.out, "error: %v\n", err)
+		}
+	}
+}
+
+// statusLine summarizes the verified enclave and the current session settings.
+func (s *chatSession) statusLine() string {
+	digest := "unverified"
+	if gt := s.client.GroundTruth(); gt != nil && gt.Digest != "" {
+		digest = gt.Digest
+		if len(digest) > 12 {
+			digest = digest[:12]
+		}
+	}
+
+	search := "off"
+	if s.webSearch {
+		search = "on"
+	}
+
+	return fmt.Sprintf("[verified %s | release %s | model %s | web search %s]",
+		s.client.Enclave(), digest, s.model, search)
+}
+
+// handleCommand executes a slash-command. It reports whether the session should end.
+func (s *chatSession) handleCommand(line string) (bool, error) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "/quit", "/exit":
+		return true, nil
+
+	case "/help":
+		fmt.Fprint(s.out, chatHelp)
+
+	case "/model":
+		if arg != "" {
+			s.model = arg
+		}
+		fmt.Fprintf(s.out, "model: %s\n", s.model)
+
+	case "/system":
+		if arg != "" {
+			s.system = arg
+		}
+		if s.system == "" {
+			fmt.Fprintln(s.out, "system prompt: (none)")
+		} else {
+			fmt.Fprintf(s.out, "system prompt: %s\n", s.system)
+		}
+
+	case "/search":
+		switch arg {
+		case "":
+			s.webSearch = !s.webSearch
+		case "on":
+			s.webSearch = true
+		case "off":
+			s.webSearch = false
+		default:
+			return false, fmt.Errorf("usage: /search [on|off]")
+		}
+		fmt.Fprintln(s.out, s.statusLine())
+
+	case "/reset":
+		s.history = nil
+		fmt.Fprintln(s.out, "conversation cleared")
+
+	case "/save":
+		if arg == "" {
+			return false, fmt.Errorf("usage: /save <path>")
+		}
+		if err := s.save(arg); err != nil {
+			return false, err
+		}
+		fmt.Fprintf(s.out, "saved %d messages to %s\n", len(s.history), arg)
+
+	case "/attestation":
+		gt := s.client.GroundTruth()
+		if gt == nil {
+			return false, fmt.Errorf("enclave has not been verified")
+		}
+		encoded, err := json.MarshalIndent(gt, "", "  ")
+		if err != nil {
+			return false, fmt.Errorf("failed to encode ground truth: %w", err)
+		}
+		fmt.Fprintf(s.out, "%s\n", encoded)
+
+	default:
+		return false, fmt.Errorf("unknown command %s (try /help)", name)
+	}
+
+	return false, nil
+}
+
+// save writes the conversation transcript to path as JSON.
+func (s *chatSession) save(path string) error {
+	transcript := chatTranscript{
+		Enclave:  s.client.Enclave(),
+		Model:    s.model,
+		System:   s.system,
+		Messages: s.history,
+	}
+	if gt := s.client.GroundTruth(); gt != nil {
+		transcript.Digest = gt.Digest
+	}
+	if transcript.Messages == nil {
+		transcript.Messages = []chatMessage{}
+	}
+
+	encoded, err := json.MarshalIndent(transcript, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode transcript: %w", err)
+	}
+	if err := os.WriteFile(path, append(encoded, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write transcript: %w", err)
+	}
+	return nil
+}
+
+// params builds the chat completion request for the current history.
+func (s *chatSession) params() openai.ChatCompletionNewParams {
+	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(s.history)+1)
+	if s.system != "" {
+		messages = append(messages, openai.SystemMessage(s.system))
+	}
+	for _, m := range s.history {
+		switch m.Role {
+		case "user":
+			messages = append(messages, openai.UserMessage(m.Content))
+		case "assistant":
+			messages = append(messages, openai.AssistantMessage(m.Content))
+		}
+	}
+
+	return openai.ChatCompletionNewParams{
+		Model:    s.model,
+		Messages: messages,
+	}
+}
+
+// send streams a reply to prompt and records both turns in the history.
+func (s *chatSession) send(ctx context.Context, prompt string) error {
+	s.history = append(s.history, chatMessage{Role: "user", Content: prompt})
+
+	var reply string
+	var err error
+	if s.webSearch {
+		reply, err = s.streamWebSearch(ctx)
+	} else {
+		reply, err = s.streamChat(ctx)
+	}
+	if err != nil {
+		// Drop the unanswered prompt so the history stays well-formed
+		s.history = s.history[:len(s.history)-1]
+		return err
+	}
+
+	s.history = append(s.history, chatMessage{Role: "assistant", Content: reply})
+	return nil
+}
+
+// streamChat streams a standard chat completion to the output. The stream
+// is read with tinfoil's SSE decoder, which tolerates the blank lines the
+// backend may send before "data: [DONE]", so every stream error is reported.
+func (s *chatSession) streamChat(ctx context.Context) (string, error) {
+	stream, err := s.client.NewWebSearchChunkStreaming(ctx, s.params(), tinfoil.WebSearchOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	var content strings.Builder
+	for stream.Next() {
+		chunk, ok := stream.Chunk()
+		if ok && len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			fmt.Fprint(s.out, chunk.Choices[0].Delta.Content)
+			content.WriteString(chunk.Choices[0].Delta.Content)
+		}
+	}
+	fmt.Fprintln(s.out)
+
+	if err := stream.Err(); err != nil {
+		return "", err
+	}
+	return content.String(), nil
+}
+
+// streamWebSearch streams a chat completion with web search enabled,
+// rendering search progress and a sources footer around the content.
+func (s *chatSession) streamWebSearch(ctx context.Context) (string, error) {
+	stream, err := s.client.NewWebSearchStreaming(ctx, s.params(), tinfoil.WebSearchOptions{Enabled: true})
+	if err != nil {
+		return "", err
+	}
+
+	r := &webSearchRenderer{out: s.out}
+	err = tinfoil.Dispatch(stream, r)
+	fmt.Fprintln(s.out)
+	if err != nil {
+		return "", err
+	}
+
+	if sources := r.message.Sources(); len(sources) > 0 {
+		fmt.Fprintln(s.out, "\nSources:")
+		for _, source := range sources {
+			title := source.Title
+			if title == "" {
+				title = source.URL
+			}
+			fmt.Fprintf(s.out, "  [%d] %s - %s\n", source.Number, title, source.URL)
+		}
+	}
+
+	return r.message.Content, nil
+}
+
+// webSearchRenderer prints web search progress and content as it streams,
+// collecting the reply and its citations.
+type webSearchRenderer struct {
+	tinfoil.NopWebSearchHandler
+	out     io.Writer
+	message tinfoil.WebSearchMessage
+}
+
+func (r *webSearchRenderer) OnSearchStarted(search *tinfoil.WebSearchCall) {
+	fmt.Fprintf(r.out, "  [searching] %s\n", searchQuery(search))
+}
+
+func (r *webSearchRenderer) OnSearchCompleted(search *tinfoil.WebSearchCall) {
+	if search.Status == tinfoil.WebSearchStatusFailed {
+		fmt.Fprintf(r.out, "  [search failed] %s: %s\n", searchQuery(search), search.Reason)
+		return
+	}
+	fmt.Fprintf(r.out, "  [searched] %s\n", searchQuery(search))
+}
+
+func (r *webSearchRenderer) OnSearchBlocked(search *tinfoil.WebSearchCall) {
+	fmt.Fprintf(r.out, "  [search blocked] %s\n", search.Reason)
+}
+
+func (r *webSearchRenderer) OnCitation(citation tinfoil.URLCitation) {
+	r.message.Annotations = append(r.message.Annotations, tinfoil.Annotation{Type: "url_citation", URLCitation: citation})
+}
+
+func (r *webSearchRenderer) OnContent(delta string) {
+	fmt.Fprint(r.out, delta)
+	r.message.Content += delta
+}
+
+func searchQuery(search *tinfoil.WebSearchCall) string {
+	if search.Action == nil {
+		return ""
+	}
+	return search.Action.Query
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tinfoilsh/tinfoil-go"
+	"github.com/tinfoilsh/tinfoil-go/tinfoiltest"
+)
+
+func TestChatCommands(t *testing.T) {
+	var out bytes.Buffer
+	s := &chatSession{out: &out, model: "llama3-3-70b"}
+
+	quit, err := s.handleCommand("/model deepseek-r1-70b")
+	require.NoError(t, err)
+	require.False(t, quit)
+	require.Equal(t, "deepseek-r1-70b", s.model)
+
+	_, err = s.handleCommand("/system   Be brief.")
+	require.NoError(t, err)
+	require.Equal(t, "Be brief.", s.system)
+
+	s.history = []chatMessage{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}}
+	_, err = s.handleCommand("/reset")
+	require.NoError(t, err)
+	require.Empty(t, s.history)
+
+	_, err = s.handleCommand("/save")
+	require.Error(t, err)
+
+	_, err = s.handleCommand("/bogus")
+	require.Error(t, err)
+
+	quit, err = s.handleCommand("/quit")
+	require.NoError(t, err)
+	require.True(t, quit)
+}
+
+func TestChatParams(t *testing.T) {
+	s := &chatSession{
+		model:  "llama3-3-70b",
+		system: "Be brief.",
+		history: []chatMessage{
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", Content: "hello"},
+			{Role: "user", Content: "how are you?"},
+		},
+	}
+
+	params := s.params()
+	require.Equal(t, "llama3-3-70b", params.Model)
+	require.Len(t, params.Messages, 4)
+	require.NotNil(t, params.Messages[0].OfSystem)
+	require.NotNil(t, params.Messages[1].OfUser)
+	require.NotNil(t, params.Messages[2].OfAssistant)
+	require.NotNil(t, params.Messages[3].OfUser)
+}
+
+func TestWebSearchRenderer(t *testing.T) {
+	stream := tinfoil.SimulateWebSearchStream(`data: {"type":"web_search_call","id":"ws_1","status":"in_progress","action":{"type":"search","query":"go news"}}
+
+data: {"type":"web_search_call","id":"ws_1","status":"completed","action":{"type":"search","query":"go news"}}
+
+data: {"choices":[{"index":0,"delta":{"annotations":[{"type":"url_citation","url_citation":{"title":"Go","url":"https://go.dev"}},{"type":"url_citation","url_citation":{"title":"Go","url":"https://go.dev"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"Go 1.25 is out."}}]}
+
+data: [DONE]
+
+`)
+
+	var out bytes.Buffer
+	r := &webSearchRenderer{out: &out}
+	require.NoError(t, tinfoil.Dispatch(stream, r))
+	require.Equal(t, "  [searching] go news\n  [searched] go news\nGo 1.25 is out.", out.String())
+	require.Equal(t, "Go 1.25 is out.", r.message.Content)
+	require.Len(t, r.message.Sources(), 1)
+}
+
+// newStreamingSession returns a chat session whose enclave answers every
+// request with the given event stream.
+func newStreamingSession(t *testing.T, stream string) (*chatSession, *bytes.Buffer) {
+	enclave := tinfoiltest.NewEnclave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, stream)
+	}))
+	t.Cleanup(enclave.Close)
+
+	c, err := enclave.NewClient()
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	return &chatSession{client: c, out: &out, model: "llama3-3-70b"}, &out
+}
+
+func TestStreamChat(t *testing.T) {
+	// A blank line before [DONE] ends the stream cleanly
+	s, out := newStreamingSession(t, `data: {"choices":[{"index":0,"delta":{"content":"Hello"}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":" there"}}]}
+
+
+data: [DONE]
+
+`)
+	reply, err := s.streamChat(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "Hello there", reply)
+	require.Equal(t, "Hello there\n", out.String())
+}
+
+func TestStreamChatReportsErrorsAfterContent(t *testing.T) {
+	s, _ := newStreamingSession(t, `data: {"choices":[{"index":0,"delta":{"content":"Hello"}}]}
+
+data: {"choices":
+
+`)
+	_, err := s.streamChat(context.Background())
+	require.Error(t, err)
+}
@@ -0,0 +1,76 @@
+// Command tinfoil provides command-line tools built on the verified Tinfoil client.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/openai/openai-go/v3/option"
+	"github.com/tinfoilsh/tinfoil-go"
+)
+
+const usage = `Usage: tinfoil <command> [flags]
+
+Commands:
+  chat    Start an interactive chat session with a verified enclave
+  proxy   Serve the OpenAI API locally, forwarding to a verified enclave
+
+Run "tinfoil <command> -h" for command-specific flags.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "chat":
+		err = runChat(os.Args[2:])
+	case "proxy":
+		err = runProxy(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "tinfoil: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tinfoil %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// clientFlags holds the flags shared by every command that talks to an enclave.
+type clientFlags struct {
+	apiKey  string
+	enclave string
+	repo    string
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.apiKey, "api-key", os.Getenv("TINFOIL_API_KEY"), "Tinfoil API key (defaults to $TINFOIL_API_KEY)")
+	fs.StringVar(&f.enclave, "enclave", "", "enclave host to verify (defaults to the Tinfoil router)")
+	fs.StringVar(&f.repo, "repo", "", "GitHub repo of the enclave's code (required with -enclave)")
+}
+
+// newClient verifies the configured enclave and returns a client bound to it.
+func (f *clientFlags) newClient() (*tinfoil.Client, error) {
+	if f.apiKey == "" {
+		return nil, fmt.Errorf("no API key: set TINFOIL_API_KEY or pass -api-key")
+	}
+	opts := []option.RequestOption{option.WithAPIKey(f.apiKey)}
+
+	switch {
+	case f.enclave == "" && f.repo == "":
+		return tinfoil.NewClient(opts...)
+	case f.enclave != "" && f.repo != "":
+		return tinfoil.NewClientWithParams(f.enclave, f.repo, opts...)
+	default:
+		return nil, fmt.Errorf("-enclave and -repo must be set together")
+	}
+}
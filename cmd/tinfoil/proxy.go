@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tinfoilsh/tinfoil-go/proxy"
+)
+
+func runProxy(args []string) error {
+	fs := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	var cf clientFlags
+	cf.register(fs)
+	listen := fs.String("listen", "127.0.0.1:8080", `address to listen on, or "unix:<path>" for a Unix socket`)
+	attestation := fs.Bool("attestation", false, "add attestation headers to responses and serve "+proxy.AttestationPath)
+	allowHosts := fs.String("allow-hosts", "", "comma-separated Host header values accepted besides loopback addresses and the listen address")
+	allowOrigins := fs.String("allow-origins", "", "comma-separated browser origins allowed to use the proxy")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := cf.newClient()
+	if err != nil {
+		return err
+	}
+
+	ln, err := proxy.Listen(*listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	p := proxy.New(c, cf.apiKey)
+	var handler http.Handler = p
+	if *attestation {
+		relay := proxy.NewRelay(c, cf.apiKey)
+		p, handler = relay.Proxy, relay
+	}
+	p.AllowedHosts = append(splitList(*allowHosts), *listen)
+	p.AllowedOrigins = splitList(*allowOrigins)
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok && !addr.IP.IsLoopback() {
+		log.Warnf("Listening on non-loopback address %s: anyone who can reach it can spend your API key", addr)
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Infof("Proxying %s/v1/ to verified enclave %s", ln.Addr(), c.Enclave())
+	if err := server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,216 @@
+// Package proxy serves the OpenAI HTTP API locally and forwards every request
+// to a verified Tinfoil enclave, so tools that cannot use this library directly
+// still get attested, direct-to-enclave connections.
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tinfoilsh/tinfoil-go"
+)
+
+// DefaultReverifyInterval is how often a proxy with failed attestation
+// attempts to re-verify the enclave before serving again.
+const DefaultReverifyInterval = 30 * time.Second
+
+// Proxy is an http.Handler that forwards /v1/* requests to the enclave
+// through the client's attested transport.
+type Proxy struct {
+	// ReverifyInterval bounds how often re-verification is attempted while
+	// attestation is failed. Requests in between, or while a re-verification
+	// is running, are refused.
+	ReverifyInterval time.Duration
+
+	// AllowedHosts lists Host header values accepted besides loopback
+	// addresses and "localhost", as "host" or "host:port". Requests for any
+	// other host are refused, so a web page cannot reach the proxy by DNS
+	// rebinding.
+	AllowedHosts []string
+
+	// AllowedOrigins lists the Origin header values, e.g.
+	// "http://localhost:3000", of browser requests that are forwarded.
+	// Requests from any other origin are refused, so web pages cannot spend
+	// the proxy's API key.
+	AllowedOrigins []string
+
+	client  *tinfoil.Client
+	reverse *httputil.ReverseProxy
+
+	mu           sync.Mutex // held by the request re-verifying the enclave
+	lastReverify time.Time
+}
+
+// New creates a proxy that forwards to the enclave verified by c. If apiKey is
+// non-empty it replaces any Authorization header sent by the caller.
+func New(c *tinfoil.Client, apiKey string) *Proxy {
+	return &Proxy{
+		ReverifyInterval: DefaultReverifyInterval,
+		client:           c,
+		reverse: &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				// Read per request: a lazily attested client only knows
+				// its enclave once attested
+				r.SetURL(&url.URL{Scheme: "https", Host: c.Enclave()})
+				if apiKey != "" {
+					r.Out.Header.Set("Authorization", "Bearer "+apiKey)
+				}
+			},
+			Transport: c.HTTPClient().Transport,
+			// Flush every write so SSE responses stream through unbuffered
+			FlushInterval: -1,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				var openErr *tinfoil.CircuitOpenError
+				if errors.As(err, &openErr) {
+					w.Header().Set("Retry-After", fmt.Sprintf("%.0f", max(time.Until(openErr.RetryAt).Seconds(), 1)))
+					writeError(w, http.StatusServiceUnavailable, "circuit_open", err.Error())
+					return
+				}
+				log.WithError(err).Warnf("Proxy request to %s failed", r.URL.Path)
+				writeError(w, http.StatusBadGateway, "upstream_error", err.Error())
+			},
+		},
+	}
+}
+
+// ServeHTTP forwards API requests to the enclave, refusing to serve while
+// the enclave's attestation is failed.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.checkCaller(w, r) {
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v1/") {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no route for %s", r.URL.Path))
+		return
+	}
+
+	if err := p.client.WaitForAttestation(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "attestation_failed", err.Error())
+		return
+	}
+	if err := p.checkAttestation(); err != nil {
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", p.ReverifyInterval.Seconds()))
+		writeError(w, http.StatusServiceUnavailable, "attestation_failed", fmt.Sprintf("enclave attestation failed: %v", err))
+		return
+	}
+
+	p.reverse.ServeHTTP(w, r)
+}
+
+// checkCaller refuses requests for a host or from a browser origin that is
+// not allowed, reporting whether the request may proceed.
+func (p *Proxy) checkCaller(w http.ResponseWriter, r *http.Request) bool {
+	if !p.allowedHost(r.Host) {
+		writeError(w, http.StatusForbidden, "forbidden_host", fmt.Sprintf("host %q is not allowed", r.Host))
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !slices.Contains(p.AllowedOrigins, origin) {
+		writeError(w, http.StatusForbidden, "forbidden_origin", fmt.Sprintf("origin %q is not allowed", origin))
+		return false
+	}
+	return true
+}
+
+// allowedHost reports whether a Host header names the proxy itself.
+func (p *Proxy) allowedHost(host string) bool {
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	if slices.Contains(p.AllowedHosts, host) || slices.Contains(p.AllowedHosts, name) {
+		return true
+	}
+	return isLoopback(name)
+}
+
+// isLoopback reports whether host is "localhost" or a loopback IP address.
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	return err == nil && ip.IsLoopback()
+}
+
+// checkAttestation returns the client's attestation error, re-verifying the
+// enclave at most once per ReverifyInterval while attestation is failed.
+// Requests arriving during a re-verification are refused rather than queued.
+func (p *Proxy) checkAttestation() error {
+	err := p.client.AttestationErr()
+	if err == nil {
+		return nil
+	}
+
+	if !p.mu.TryLock() {
+		return err
+	}
+	defer p.mu.Unlock()
+
+	// Another request may have re-verified since we checked
+	if err = p.client.AttestationErr(); err == nil {
+		return nil
+	}
+	if time.Since(p.lastReverify) < p.ReverifyInterval {
+		return err
+	}
+
+	p.lastReverify = time.Now()
+	if _, err := p.client.Verify(); err != nil {
+		return err
+	}
+	log.Info("Proxy re-verified enclave attestation, resuming")
+	return nil
+}
+
+// writeError writes an error body in the OpenAI API format so that clients
+// surface the message to their users.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{
+			"message": message,
+			"type":    "tinfoil_proxy_error",
+			"code":    code,
+		},
+	})
+}
+
+// Listen opens a listener for addr, which is either a TCP address such as
+// "127.0.0.1:8080" or a Unix socket path prefixed with "unix:". A stale
+// socket file left at the path by a previous run is removed once dialing it
+// shows nothing listens on it anymore.
+func Listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		// Only a socket nobody listens on is stale
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is in use by another process", path)
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			if err := os.Remove(path); err != nil {
+				return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+			}
+		}
+	}
+	return net.Listen("unix", path)
+}
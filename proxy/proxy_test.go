@@ -0,0 +1,314 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tinfoilsh/tinfoil-go"
+	"github.com/tinfoilsh/tinfoil-go/tinfoiltest"
+	"github.com/tinfoilsh/verifier/client"
+)
+
+// newTestProxy starts a proxy in front of a fake enclave serving handler.
+func newTestProxy(t *testing.T, handler http.Handler) (*tinfoiltest.Enclave, *Proxy, *httptest.Server) {
+	enclave := tinfoiltest.NewEnclave(handler)
+	t.Cleanup(enclave.Close)
+
+	c, err := enclave.NewClient()
+	require.NoError(t, err)
+
+	p := New(c, "injected-key")
+	server := httptest.NewServer(p)
+	t.Cleanup(server.Close)
+	return enclave, p, server
+}
+
+func TestProxyForwardsWithAPIKey(t *testing.T) {
+	_, _, server := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/models", r.URL.Path)
+		require.Equal(t, "Bearer injected-key", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"data":[]}`))
+	}))
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/models", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer caller-key")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `{"data":[]}`, string(body))
+}
+
+func TestProxyStreamsUnbuffered(t *testing.T) {
+	release := make(chan struct{})
+	_, _, server := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer close(release)
+
+	resp, err := http.Post(server.URL+"/v1/chat/completions", "application/json", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// The first event must arrive while the enclave is still holding the stream open
+	lines := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		require.Equal(t, "data: first\n", line)
+	case <-time.After(5 * time.Second):
+		t.Fatal("streamed event was buffered by the proxy")
+	}
+}
+
+func TestProxyRefusesWhileAttestationFailed(t *testing.T) {
+	enclave, p, server := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	p.ReverifyInterval = time.Hour
+
+	enclave.FailAttestation(tinfoiltest.ErrAttestationFailed)
+	enclave.RotateCertificate()
+
+	resp, err := http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "3600", resp.Header.Get("Retry-After"))
+
+	// Once the enclave attests again the proxy resumes on its next re-verification
+	enclave.FailAttestation(nil)
+	p.ReverifyInterval = 0
+
+	resp, err = http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// gatedVerifier blocks Verify while gate is set, signalling entered first.
+type gatedVerifier struct {
+	tinfoil.Verifier
+	gate    atomic.Pointer[chan struct{}]
+	entered chan struct{}
+}
+
+func (v *gatedVerifier) Verify() (*client.GroundTruth, error) {
+	if gate := v.gate.Load(); gate != nil {
+		v.entered <- struct{}{}
+		<-*gate
+	}
+	return v.Verifier.Verify()
+}
+
+func TestProxyRefusesDuringReverify(t *testing.T) {
+	enclave := tinfoiltest.NewEnclave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	t.Cleanup(enclave.Close)
+
+	v := &gatedVerifier{Verifier: enclave.Verifier(), entered: make(chan struct{}, 1)}
+	c, err := tinfoil.NewClientWithVerifier(v)
+	require.NoError(t, err)
+	p := New(c, "injected-key")
+	p.ReverifyInterval = 0
+	server := httptest.NewServer(p)
+	t.Cleanup(server.Close)
+
+	// Fail attestation so the next request re-verifies
+	enclave.FailAttestation(tinfoiltest.ErrAttestationFailed)
+	enclave.RotateCertificate()
+	resp, err := http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	enclave.FailAttestation(nil)
+
+	gate := make(chan struct{})
+	v.gate.Store(&gate)
+	reverified := make(chan int)
+	go func() {
+		resp, err := http.Get(server.URL + "/v1/models")
+		if err != nil {
+			reverified <- 0
+			return
+		}
+		resp.Body.Close()
+		reverified <- resp.StatusCode
+	}()
+	<-v.entered
+
+	// Another request is refused right away instead of waiting
+	resp, err = http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	v.gate.Store(nil)
+	close(gate)
+	require.Equal(t, http.StatusOK, <-reverified)
+}
+
+func TestProxyLazyClient(t *testing.T) {
+	enclave := tinfoiltest.NewEnclave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	t.Cleanup(enclave.Close)
+	enclave.FailAttestation(tinfoiltest.ErrAttestationFailed)
+
+	c, err := enclave.NewClient(tinfoil.WithAttestation(tinfoil.AttestLazy))
+	require.NoError(t, err)
+	server := httptest.NewServer(New(c, "injected-key"))
+	t.Cleanup(server.Close)
+
+	// The first requests attest, and are refused until that succeeds
+	resp, err := http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	enclave.FailAttestation(nil)
+	resp, err = http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestProxyCircuitOpen(t *testing.T) {
+	enclave := tinfoiltest.NewEnclave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(enclave.Close)
+
+	c, err := tinfoil.NewClientWithOptions(enclave.Verifier(), tinfoil.ClientOptions{
+		CircuitBreaker: &tinfoil.CircuitBreaker{MinRequests: 1, OpenTimeout: time.Minute},
+	})
+	require.NoError(t, err)
+	server := httptest.NewServer(New(c, "injected-key"))
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	// The failure opened the circuit, so the proxy fails fast
+	resp, err = http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.NotEmpty(t, resp.Header.Get("Retry-After"))
+}
+
+func TestProxyRejectsNonAPIPaths(t *testing.T) {
+	p := &Proxy{}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/health", nil))
+
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+			Code    string `json:"code"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Equal(t, "not_found", body.Error.Code)
+	require.Contains(t, body.Error.Message, "/health")
+}
+
+func TestProxyRejectsForeignCallers(t *testing.T) {
+	_, p, server := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	p.AllowedHosts = []string{"proxy.internal"}
+	p.AllowedOrigins = []string{"http://localhost:3000"}
+
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   int
+	}{
+		{"loopback", "", "", http.StatusOK},
+		{"localhost", "localhost:8080", "", http.StatusOK},
+		{"ipv6 loopback", "[::1]:8080", "", http.StatusOK},
+		{"allowed host", "proxy.internal:8080", "", http.StatusOK},
+		{"rebound host", "attacker.example.com", "", http.StatusForbidden},
+		{"allowed origin", "", "http://localhost:3000", http.StatusOK},
+		{"web page", "", "https://attacker.example.com", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions", nil)
+			require.NoError(t, err)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, tt.want, resp.StatusCode)
+		})
+	}
+}
+
+func TestListen(t *testing.T) {
+	tcp, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	require.Equal(t, "tcp", tcp.Addr().Network())
+	tcp.Close()
+
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	unix, err := Listen("unix:" + path)
+	require.NoError(t, err)
+	require.Equal(t, "unix", unix.Addr().Network())
+
+	// Simulate a stale socket left behind by a crashed process
+	unix.(*net.UnixListener).SetUnlinkOnClose(false)
+	unix.Close()
+
+	unix, err = Listen("unix:" + path)
+	require.NoError(t, err)
+	defer unix.Close()
+
+	// A socket another process still listens on is left alone
+	_, err = Listen("unix:" + path)
+	require.ErrorContains(t, err, "in use")
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	conn.Close()
+}
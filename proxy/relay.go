@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tinfoilsh/tinfoil-go"
+)
+
+// Response headers added by a Relay describing the attestation a response
+// was served under.
+const (
+	HeaderReleaseDigest         = "Tinfoil-Release-Digest"
+	HeaderAttestationGeneration = "Tinfoil-Attestation-Generation"
+	HeaderVerifiedAt            = "Tinfoil-Verified-At"
+)
+
+// AttestationPath is where a Relay serves the current attestation as JSON.
+const AttestationPath = "/tinfoil/attestation"
+
+// Relay is an embeddable http.Handler that forwards requests to the enclave
+// like Proxy and tells downstream callers what was attested: every response
+// relayed from the enclave carries attestation headers, and AttestationPath
+// serves the client's current AttestationStatus. Responses the relay makes
+// itself, such as errors while attestation is failed, carry none.
+type Relay struct {
+	*Proxy
+}
+
+// NewRelay creates a relay that forwards to the enclave verified by c,
+// injecting apiKey as Proxy does.
+func NewRelay(c *tinfoil.Client, apiKey string) *Relay {
+	p := New(c, apiKey)
+	// Report the attestation of the connection that served the response,
+	// overriding headers of the same name sent by the enclave
+	p.reverse.ModifyResponse = func(resp *http.Response) error {
+		for _, name := range []string{HeaderReleaseDigest, HeaderAttestationGeneration, HeaderVerifiedAt} {
+			resp.Header.Del(name)
+		}
+		if status, ok := tinfoil.ResponseAttestation(resp); ok {
+			setAttestationHeaders(resp.Header, status)
+		}
+		return nil
+	}
+	return &Relay{Proxy: p}
+}
+
+// ServeHTTP serves the attestation endpoint and relays everything else.
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == AttestationPath {
+		if !r.checkCaller(w, req) {
+			return
+		}
+		r.serveAttestation(w, req)
+		return
+	}
+
+	r.Proxy.ServeHTTP(w, req)
+}
+
+func (r *Relay) serveAttestation(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "attestation endpoint only supports GET")
+		return
+	}
+
+	status := r.client.Attestation()
+	setAttestationHeaders(w.Header(), status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(status)
+}
+
+func setAttestationHeaders(h http.Header, status tinfoil.AttestationStatus) {
+	if status.GroundTruth != nil {
+		h.Set(HeaderReleaseDigest, status.GroundTruth.Digest)
+	}
+	h.Set(HeaderAttestationGeneration, strconv.FormatUint(status.Generation, 10))
+	h.Set(HeaderVerifiedAt, status.VerifiedAt.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tinfoilsh/tinfoil-go"
+	"github.com/tinfoilsh/tinfoil-go/tinfoiltest"
+	"github.com/tinfoilsh/verifier/client"
+)
+
+func TestSetAttestationHeaders(t *testing.T) {
+	verifiedAt := time.Date(2026, 3, 1, 12, 30, 0, 0, time.FixedZone("EST", -5*3600))
+
+	h := http.Header{}
+	h.Set(HeaderReleaseDigest, "spoofed-by-upstream")
+	setAttestationHeaders(h, tinfoil.AttestationStatus{
+		GroundTruth: &client.GroundTruth{Digest: "abc123"},
+		Generation:  3,
+		VerifiedAt:  verifiedAt,
+	})
+
+	require.Equal(t, []string{"abc123"}, h.Values(HeaderReleaseDigest))
+	require.Equal(t, "3", h.Get(HeaderAttestationGeneration))
+	require.Equal(t, "2026-03-01T17:30:00Z", h.Get(HeaderVerifiedAt))
+}
+
+func TestRelayAttestationEndpointMethods(t *testing.T) {
+	r := &Relay{Proxy: &Proxy{}}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://localhost"+AttestationPath, nil))
+
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	require.Equal(t, "GET, HEAD", rec.Header().Get("Allow"))
+}
+
+func TestRelay(t *testing.T) {
+	enclave := tinfoiltest.NewEnclave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderReleaseDigest, "spoofed-by-enclave")
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer enclave.Close()
+
+	c, err := enclave.NewClient()
+	require.NoError(t, err)
+
+	server := httptest.NewServer(NewRelay(c, "injected-key"))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []string{tinfoiltest.DefaultDigest}, resp.Header.Values(HeaderReleaseDigest))
+	require.Equal(t, "1", resp.Header.Get(HeaderAttestationGeneration))
+
+	// Relayed responses report the attestation that served them
+	enclave.RotateCertificate()
+	resp, err = http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, "2", resp.Header.Get(HeaderAttestationGeneration))
+
+	resp, err = http.Get(server.URL + AttestationPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var status tinfoil.AttestationStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	require.Equal(t, uint64(2), status.Generation)
+	require.Equal(t, enclave.GroundTruth().TLSPublicKey, status.GroundTruth.TLSPublicKey)
+	require.False(t, status.LastRotation.IsZero())
+}
+
+func TestRelayReportsServingAttestation(t *testing.T) {
+	var c *tinfoil.Client
+	var reattested atomic.Bool
+	enclave := tinfoiltest.NewEnclave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Another request re-attests while this response is being served
+		if !reattested.Swap(true) {
+			_, err := c.Verify()
+			require.NoError(t, err)
+		}
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	t.Cleanup(enclave.Close)
+
+	var err error
+	c, err = enclave.NewClient()
+	require.NoError(t, err)
+	server := httptest.NewServer(NewRelay(c, "injected-key"))
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get(HeaderAttestationGeneration))
+	require.Equal(t, uint64(2), c.Attestation().Generation)
+}
+
+func TestRelayErrorsCarryNoAttestationHeaders(t *testing.T) {
+	enclave := tinfoiltest.NewEnclave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	t.Cleanup(enclave.Close)
+	enclave.FailAttestation(tinfoiltest.ErrAttestationFailed)
+
+	c, err := enclave.NewClient(tinfoil.WithAttestation(tinfoil.AttestLazy))
+	require.NoError(t, err)
+	server := httptest.NewServer(NewRelay(c, "injected-key"))
+	t.Cleanup(server.Close)
+
+	// Nothing was attested, so the relay's own errors must not claim otherwise
+	for _, path := range []string{"/v1/models", "/other"} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.NotEqual(t, http.StatusOK, resp.StatusCode)
+		require.Empty(t, resp.Header.Get(HeaderReleaseDigest))
+		require.Empty(t, resp.Header.Get(HeaderAttestationGeneration))
+		require.Empty(t, resp.Header.Get(HeaderVerifiedAt))
+	}
+
+	enclave.FailAttestation(nil)
+	resp, err := http.Get(server.URL + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get(HeaderAttestationGeneration))
+}
@@ -0,0 +1,481 @@
+package tinfoil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3/option"
+)
+
+// RateLimit configures client-side rate limiting of a Client's requests with
+// token buckets. Zero rates are unlimited. Rate limit response headers lower
+// the configured rates, or limit unlimited ones, for the window they describe
+// and pause requests once a limit is exhausted, and a 429 response pauses
+// requests until its Retry-After has passed.
+type RateLimit struct {
+	// RequestsPerSecond limits the request rate, allowing bursts of up to
+	// RequestBurst requests. RequestBurst defaults to RequestsPerSecond
+	// rounded up.
+	RequestsPerSecond float64
+	RequestBurst      int
+
+	// TokensPerMinute limits the tokens used per minute, allowing a burst of
+	// a full minute's tokens. Each request is charged an estimate up front,
+	// corrected once the response reports its usage.
+	TokensPerMinute int
+
+	// EstimateTokens estimates the tokens a request will use from its body.
+	// Defaults to EstimateTokens.
+	EstimateTokens func(body []byte) int
+}
+
+// WithRateLimit returns an option that sets ClientOptions.RateLimit when
+// passed to a Client constructor, such as NewClient.
+func WithRateLimit(limit RateLimit) option.RequestOption {
+	return newClientOption(func(opts *ClientOptions) { opts.RateLimit = &limit })
+}
+
+// Validate reports whether the rate limit is usable.
+func (l RateLimit) Validate() error {
+	if l.RequestsPerSecond < 0 || l.RequestBurst < 0 || l.TokensPerMinute < 0 {
+		return fmt.Errorf("invalid rate limit: rates and burst must not be negative")
+	}
+	return nil
+}
+
+// EstimateTokens estimates the tokens used by a request from the size of its
+// body, at about four bytes per token, plus the output tokens it requests
+// with max_tokens, max_completion_tokens or max_output_tokens.
+func EstimateTokens(body []byte) int {
+	estimate := len(body) / 4
+
+	var limits struct {
+		MaxTokens           int `json:"max_tokens"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+		MaxOutputTokens     int `json:"max_output_tokens"`
+	}
+	if json.Unmarshal(body, &limits) == nil {
+		estimate += max(limits.MaxTokens, limits.MaxCompletionTokens, limits.MaxOutputTokens)
+	}
+	return max(estimate, 1)
+}
+
+// tokenBucket is a reservation-based token bucket. Reservations may take the
+// balance negative; the caller then waits until it is paid back, so waiters
+// are served in order. A zero rate is unlimited.
+type tokenBucket struct {
+	rate     float64 // tokens per second
+	capacity float64
+	tokens   float64
+	last     time.Time
+
+	// The configured rate and capacity, restored once the server limits
+	// applied by limit expire at serverUntil
+	configRate     float64
+	configCapacity float64
+	serverUntil    time.Time
+}
+
+func newTokenBucket(rate, capacity float64, now time.Time) tokenBucket {
+	return tokenBucket{
+		rate:           rate,
+		capacity:       capacity,
+		tokens:         capacity,
+		last:           now,
+		configRate:     rate,
+		configCapacity: capacity,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	if !b.serverUntil.IsZero() && !now.Before(b.serverUntil) {
+		b.rate = b.configRate
+		b.capacity = b.configCapacity
+		b.tokens = min(b.tokens, b.capacity)
+		b.serverUntil = time.Time{}
+	}
+}
+
+// reserve takes n tokens and returns how long to wait before using them.
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	if b.rate == 0 {
+		return 0
+	}
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// adjust returns n tokens to the bucket, or takes -n more.
+func (b *tokenBucket) adjust(n float64, now time.Time) {
+	b.refill(now)
+	if b.rate == 0 {
+		return
+	}
+	b.tokens = min(b.capacity, b.tokens+n)
+}
+
+// limit applies a server limit of limit tokens per window, with remaining
+// tokens left that are fully replenished after reset. The window is derived
+// from how fast reset replenishes the used tokens, or is a minute if nothing
+// has been used. Until the window has passed, the bucket runs at the lower of
+// the server and configured limits, including when it is otherwise
+// unlimited. A negative limit only caps the balance at remaining.
+func (b *tokenBucket) limit(limit, remaining float64, reset time.Duration, now time.Time) {
+	b.refill(now)
+	if limit > 0 {
+		window := time.Minute
+		if reset > 0 && remaining >= 0 && remaining < limit {
+			window = time.Duration(float64(reset) * limit / (limit - remaining))
+		}
+		if b.rate == 0 {
+			b.tokens = limit
+		}
+		b.rate = lowerLimit(b.configRate, limit/window.Seconds())
+		b.capacity = lowerLimit(b.configCapacity, limit)
+		b.tokens = min(b.tokens, b.capacity)
+		b.serverUntil = now.Add(window)
+	}
+	if remaining >= 0 && b.rate > 0 {
+		b.tokens = min(b.tokens, remaining)
+	}
+}
+
+// lowerLimit returns the lower of a configured limit, where zero is
+// unlimited, and a server limit.
+func lowerLimit(configured, server float64) float64 {
+	if configured == 0 {
+		return server
+	}
+	return min(configured, server)
+}
+
+// rateLimiter combines the request and token buckets with pauses requested
+// by the server.
+type rateLimiter struct {
+	estimate func(body []byte) int
+
+	mu          sync.Mutex
+	requests    tokenBucket
+	tokens      tokenBucket
+	pausedUntil time.Time
+}
+
+func newRateLimiter(l RateLimit) *rateLimiter {
+	now := time.Now()
+	burst := l.RequestBurst
+	if burst == 0 {
+		burst = max(int(math.Ceil(l.RequestsPerSecond)), 1)
+	}
+	estimate := l.EstimateTokens
+	if estimate == nil {
+		estimate = EstimateTokens
+	}
+	return &rateLimiter{
+		estimate: estimate,
+		requests: newTokenBucket(l.RequestsPerSecond, float64(burst), now),
+		tokens:   newTokenBucket(float64(l.TokensPerMinute)/60, float64(l.TokensPerMinute), now),
+	}
+}
+
+// reserve takes one request and tokens estimated tokens, returning how long
+// to wait before sending.
+func (l *rateLimiter) reserve(tokens int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	wait := max(l.requests.reserve(1, now), l.tokens.reserve(float64(tokens), now))
+	return max(wait, l.pausedUntil.Sub(now))
+}
+
+// cancel returns a reservation that was not used.
+func (l *rateLimiter) cancel(tokens int, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests.adjust(1, now)
+	l.tokens.adjust(float64(tokens), now)
+}
+
+// settle corrects a request's estimated tokens with its actual usage.
+func (l *rateLimiter) settle(estimated, actual int, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens.adjust(float64(estimated-actual), now)
+}
+
+// pause blocks new requests for d.
+func (l *rateLimiter) pause(d time.Duration, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := now.Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// observe adapts the limiter to a response's rate limit headers and status.
+func (l *rateLimiter) observe(resp *http.Response, now time.Time) {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait, ok := retryAfter(resp.Header, now)
+		if !ok {
+			wait = time.Second
+		}
+		l.pause(wait, now)
+	}
+
+	for _, kind := range []string{"requests", "tokens"} {
+		limit, remaining, reset, ok := parseRateLimitHeaders(resp.Header, kind)
+		if !ok {
+			continue
+		}
+
+		l.mu.Lock()
+		bucket := &l.requests
+		if kind == "tokens" {
+			bucket = &l.tokens
+		}
+		bucket.limit(limit, remaining, reset, now)
+		l.mu.Unlock()
+
+		if remaining == 0 && reset > 0 {
+			l.pause(reset, now)
+		}
+	}
+}
+
+// parseRateLimitHeaders reads the x-ratelimit-limit-, -remaining- and
+// -reset- headers of kind "requests" or "tokens". Missing values are -1.
+func parseRateLimitHeaders(header http.Header, kind string) (limit, remaining float64, reset time.Duration, ok bool) {
+	limit, remaining, reset = -1, -1, 0
+	if v, err := strconv.ParseFloat(header.Get("X-Ratelimit-Limit-"+kind), 64); err == nil && v >= 0 {
+		limit, ok = v, true
+	}
+	if v, err := strconv.ParseFloat(header.Get("X-Ratelimit-Remaining-"+kind), 64); err == nil && v >= 0 {
+		remaining, ok = v, true
+	}
+	if v := header.Get("X-Ratelimit-Reset-" + kind); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			reset = d
+		} else if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+			reset = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return limit, remaining, reset, ok
+}
+
+// rateLimitTransport delays requests to stay within a rateLimiter.
+type rateLimitTransport struct {
+	limiter *rateLimiter
+	next    http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, req, err := peekBody(req)
+	if err != nil {
+		return nil, err
+	}
+	tokens := t.limiter.estimate(body)
+
+	wait := t.limiter.reserve(tokens, time.Now())
+	if err := sleepContext(req.Context(), wait); err != nil {
+		t.limiter.cancel(tokens, time.Now())
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.limiter.observe(resp, time.Now())
+	if resp.StatusCode >= 400 {
+		// Rejected requests use no tokens
+		t.limiter.settle(tokens, 0, time.Now())
+		return resp, nil
+	}
+
+	resp.Body = &usageReader{
+		ReadCloser: resp.Body,
+		sse:        isEventStream(resp.Header),
+		settle: func(actual int) {
+			t.limiter.settle(tokens, actual, time.Now())
+		},
+	}
+	return resp, nil
+}
+
+// peekBody returns a copy of the request body and a request that can still
+// send it, cloning the request if its body had to be consumed.
+func peekBody(req *http.Request) ([]byte, *http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, req, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		return data, req, nil
+	}
+
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	return data, req, nil
+}
+
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// maxUsageBuffer bounds the response data buffered to find its usage.
+const maxUsageBuffer = 8 << 20
+
+// usageReader passes a response body through, looking for the usage it
+// reports: the body of a JSON response, or the data lines of an event stream.
+// settle is called once the body is read or closed, if usage was found.
+// Close may be called concurrently with a blocked Read, as when a stream's
+// context is canceled.
+type usageReader struct {
+	io.ReadCloser
+	sse    bool
+	settle func(actual int)
+
+	mu       sync.Mutex // guards the fields below, not the underlying reads
+	buf      []byte
+	overflow bool
+	settled  bool
+	found    int
+}
+
+func (r *usageReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.mu.Lock()
+	r.scan(p[:n])
+	var actual int
+	if err == io.EOF {
+		actual = r.finish()
+	}
+	r.mu.Unlock()
+
+	r.settleFound(actual)
+	return n, err
+}
+
+func (r *usageReader) Close() error {
+	r.mu.Lock()
+	actual := r.finish()
+	r.mu.Unlock()
+
+	r.settleFound(actual)
+	return r.ReadCloser.Close()
+}
+
+// scan looks for usage in the next data of the body. r.mu must be held.
+func (r *usageReader) scan(data []byte) {
+	if r.settled {
+		return
+	}
+	if !r.sse {
+		if len(r.buf)+len(data) > maxUsageBuffer {
+			r.overflow = true
+			r.buf = nil
+		}
+		if !r.overflow {
+			r.buf = append(r.buf, data...)
+		}
+		return
+	}
+
+	r.buf = append(r.buf, data...)
+	for {
+		i := bytes.IndexByte(r.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSpace(r.buf[:i])
+		r.buf = r.buf[i+1:]
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			if tokens, ok := parseUsage(bytes.TrimSpace(data)); ok {
+				r.found = tokens
+			}
+		}
+	}
+	if len(r.buf) > maxUsageBuffer {
+		r.buf = nil
+	}
+}
+
+// finish ends the scan the first time it is called, returning the usage
+// found, or 0 if there is none or it was already returned. r.mu must be held.
+func (r *usageReader) finish() int {
+	if r.settled {
+		return 0
+	}
+	r.settled = true
+	if !r.sse && !r.overflow {
+		if tokens, ok := parseUsage(r.buf); ok {
+			r.found = tokens
+		}
+	}
+	r.buf = nil
+	return r.found
+}
+
+// settleFound reports usage returned by finish outside of r.mu.
+func (r *usageReader) settleFound(actual int) {
+	// Without reported usage, keep the estimate
+	if actual > 0 {
+		r.settle(actual)
+	}
+}
+
+// parseUsage reads the total tokens of a chat completion, chunk or Responses
+// API event.
+func parseUsage(data []byte) (int, bool) {
+	if !bytes.Contains(data, []byte(`"usage"`)) {
+		return 0, false
+	}
+
+	type usage struct {
+		TotalTokens int `json:"total_tokens"`
+	}
+	var v struct {
+		Usage    *usage `json:"usage"`
+		Response *struct {
+			Usage *usage `json:"usage"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return 0, false
+	}
+	switch {
+	case v.Usage != nil:
+		return v.Usage.TotalTokens, true
+	case v.Response != nil && v.Response.Usage != nil:
+		return v.Response.Usage.TotalTokens, true
+	}
+	return 0, false
+}
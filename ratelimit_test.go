@@ -0,0 +1,305 @@
+package tinfoil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3/option"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 10, now)
+
+	require.Zero(t, b.reserve(10, now))
+	require.Equal(t, 500*time.Millisecond, b.reserve(5, now))
+
+	// Waiters queue behind earlier reservations
+	require.Equal(t, time.Second, b.reserve(5, now))
+	require.Equal(t, 500*time.Millisecond, b.reserve(0, now.Add(500*time.Millisecond)))
+
+	unlimited := newTokenBucket(0, 0, now)
+	require.Zero(t, unlimited.reserve(1000, now))
+}
+
+func TestRateLimiterSettle(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(RateLimit{TokensPerMinute: 600})
+
+	require.Zero(t, l.reserve(600, now))
+	require.Equal(t, 10*time.Second, l.reserve(100, now))
+
+	// The response used far fewer tokens than estimated
+	l.settle(600, 100, now)
+	require.Zero(t, l.reserve(100, now))
+
+	l.cancel(100, now)
+	require.InDelta(t, 400, l.tokens.tokens, 0.01)
+}
+
+func TestRateLimiterObserve(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(RateLimit{RequestsPerSecond: 100, TokensPerMinute: 60000})
+
+	// A 429 pauses every request until Retry-After has passed
+	l.observe(&http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"2"}}}, now)
+	require.Equal(t, 2*time.Second, l.reserve(1, now))
+	require.Zero(t, l.reserve(1, now.Add(3*time.Second)))
+
+	// Server limits below the configured rates take over, over the window
+	// their reset implies: one minute for requests, 30s for tokens
+	later := now.Add(time.Minute)
+	l.observe(&http.Response{StatusCode: http.StatusOK, Header: http.Header{
+		"X-Ratelimit-Limit-Requests":     {"600"},
+		"X-Ratelimit-Remaining-Requests": {"599"},
+		"X-Ratelimit-Reset-Requests":     {"100ms"},
+		"X-Ratelimit-Limit-Tokens":       {"6000"},
+		"X-Ratelimit-Remaining-Tokens":   {"0"},
+		"X-Ratelimit-Reset-Tokens":       {"30s"},
+	}}, later)
+	require.InDelta(t, 10, l.requests.rate, 0.001)
+	require.InDelta(t, 200, l.tokens.rate, 0.001)
+	require.Equal(t, 30*time.Second, l.reserve(1, later))
+}
+
+func TestTokenBucketServerLimits(t *testing.T) {
+	now := time.Now()
+
+	// An unconfigured bucket follows the server limit, here 1000 per day
+	unlimited := newTokenBucket(0, 0, now)
+	unlimited.limit(1000, 990, 864*time.Second, now)
+	require.InDelta(t, 1000.0/86400, unlimited.rate, 1e-9)
+	require.InDelta(t, 990, unlimited.tokens, 0.01)
+	require.NotZero(t, unlimited.reserve(1000, now))
+
+	// It is unlimited again once the day has passed
+	require.Zero(t, unlimited.reserve(1e6, now.Add(25*time.Hour)))
+	require.Zero(t, unlimited.rate)
+
+	// A configured bucket lowered by the server recovers when the server
+	// reports a higher limit, and after the window without one
+	b := newTokenBucket(10, 10, now)
+	b.limit(60, 59, time.Second, now)
+	require.InDelta(t, 1, b.rate, 0.001)
+	b.limit(6000, 5990, time.Second, now)
+	require.InDelta(t, 10, b.rate, 0.001)
+
+	b.limit(60, -1, 0, now)
+	require.InDelta(t, 1, b.rate, 0.001)
+	b.refill(now.Add(time.Minute))
+	require.InDelta(t, 10, b.rate, 0.001)
+	require.InDelta(t, 10, b.capacity, 0.001)
+}
+
+func TestParseRateLimitHeaders(t *testing.T) {
+	header := http.Header{
+		"X-Ratelimit-Limit-Tokens":     {"150000"},
+		"X-Ratelimit-Remaining-Tokens": {"149984"},
+		"X-Ratelimit-Reset-Tokens":     {"6m0s"},
+		"X-Ratelimit-Reset-Requests":   {"20"},
+	}
+
+	limit, remaining, reset, ok := parseRateLimitHeaders(header, "tokens")
+	require.True(t, ok)
+	require.Equal(t, 150000.0, limit)
+	require.Equal(t, 149984.0, remaining)
+	require.Equal(t, 6*time.Minute, reset)
+
+	_, _, _, ok = parseRateLimitHeaders(header, "requests")
+	require.False(t, ok)
+}
+
+func TestEstimateTokens(t *testing.T) {
+	require.Equal(t, 1, EstimateTokens(nil))
+	require.Equal(t, 100, EstimateTokens([]byte(strings.Repeat("a", 400))))
+
+	body := `{"model":"m","max_completion_tokens":1000}`
+	require.Equal(t, len(body)/4+1000, EstimateTokens([]byte(body)))
+}
+
+func TestUsageReader(t *testing.T) {
+	tests := []struct {
+		name string
+		sse  bool
+		body string
+		want int
+	}{
+		{"chat completion", false, `{"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":7,"total_tokens":12}}`, 12},
+		{"chat stream", true, "data: {\"choices\":[]}\n\ndata: {\"choices\":[],\"usage\":{\"total_tokens\":30}}\n\ndata: [DONE]\n\n", 30},
+		{"responses stream", true, "data: {\"type\":\"response.completed\",\"response\":{\"usage\":{\"total_tokens\":42}}}\n\n", 42},
+		{"no usage", false, `{"choices":[]}`, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := -1
+			r := &usageReader{
+				ReadCloser: io.NopCloser(strings.NewReader(tt.body)),
+				sse:        tt.sse,
+				settle:     func(actual int) { got = actual },
+			}
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, tt.body, string(data))
+			require.NoError(t, r.Close())
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{TokensPerMinute: 6000})
+	var sent string
+	transport := &rateLimitTransport{limiter: limiter, next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		sent = string(body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"usage":{"total_tokens":10}}`)),
+			Request:    req,
+		}, nil
+	})}
+
+	// Bodies without GetBody are still sent in full
+	payload := `{"max_tokens":2000}`
+	req, _ := http.NewRequest(http.MethodPost, "https://enclave.example.com/v1/chat/completions", io.NopCloser(strings.NewReader(payload)))
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	require.Equal(t, payload, sent)
+	require.InDelta(t, 6000-EstimateTokens([]byte(payload)), limiter.tokens.tokens, 1)
+
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.InDelta(t, 6000-10, limiter.tokens.tokens, 5)
+}
+
+func TestRateLimitTransportCanceled(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{RequestsPerSecond: 0.001})
+	transport := &rateLimitTransport{limiter: limiter, next: roundTripFunc(okTransport)}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://enclave.example.com/health", nil)
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// The second request would wait for minutes
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = transport.RoundTrip(req.WithContext(ctx))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.InDelta(t, 0, limiter.requests.tokens, 0.01)
+}
+
+func TestRateLimitValidate(t *testing.T) {
+	require.NoError(t, RateLimit{}.Validate())
+	require.Error(t, RateLimit{TokensPerMinute: -1}.Validate())
+
+	_, err := NewClientWithOptions(&fakeVerifier{}, ClientOptions{RateLimit: &RateLimit{RequestsPerSecond: -1}})
+	require.Error(t, err)
+	_, err = NewClientWithVerifier(&fakeVerifier{}, WithRateLimit(RateLimit{TokensPerMinute: -1}))
+	require.Error(t, err)
+}
+
+func TestClientRateLimitOption(t *testing.T) {
+	c, err := NewClientWithVerifier(&fakeVerifier{transports: []http.RoundTripper{roundTripFunc(okTransport)}},
+		WithRateLimit(RateLimit{RequestsPerSecond: 0.001}))
+	require.NoError(t, err)
+
+	resp, err := c.HTTPClient().Get("https://enclave.example.com/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// The burst of one request is spent, so the next one waits
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://enclave.example.com/health", nil)
+	_, err = c.HTTPClient().Do(req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClientRateLimitWithRetry(t *testing.T) {
+	var attempts int
+	transport := handlerTransport(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After-Ms", "20")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		io.WriteString(w, "ok")
+	})
+
+	c, err := NewClientWithOptions(&fakeVerifier{transports: []http.RoundTripper{transport}}, ClientOptions{
+		Retry:     &fastRetries,
+		RateLimit: &RateLimit{RequestsPerSecond: 1000},
+	})
+	require.NoError(t, err)
+
+	// The retry waits out the 429 and the limiter's pause
+	start := time.Now()
+	resp, err := c.HTTPClient().Get("https://enclave.example.com/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 2, attempts)
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+// slowBody returns data, then blocks in Read for delay before failing. Its
+// Close does not synchronize with Read, like a connection closed under a
+// pending read.
+type slowBody struct {
+	data  string
+	delay time.Duration
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if b.data != "" {
+		n := copy(p, b.data)
+		b.data = b.data[n:]
+		return n, nil
+	}
+	time.Sleep(b.delay)
+	return 0, io.ErrClosedPipe
+}
+
+func (b *slowBody) Close() error { return nil }
+
+func TestClientRateLimitCanceledStream(t *testing.T) {
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/event-stream"}},
+			Body:       &slowBody{data: "data: {\"choices\":[],\"usage\":{\"total_tokens\":10}}\n\n", delay: 100 * time.Millisecond},
+			Request:    req,
+		}, nil
+	})
+
+	c, err := NewClientWithOptions(&fakeVerifier{transports: []http.RoundTripper{transport}}, ClientOptions{
+		RateLimit: &RateLimit{TokensPerMinute: 6000},
+	}, option.WithMaxRetries(0))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := c.NewWebSearchStreaming(ctx, webSearchTestParams, WebSearchOptions{})
+	require.NoError(t, err)
+	defer stream.Close()
+	require.True(t, stream.Next())
+
+	// Canceling closes the body while Next is blocked reading it
+	time.AfterFunc(20*time.Millisecond, cancel)
+	require.False(t, stream.Next())
+	require.ErrorIs(t, stream.Err(), context.Canceled)
+
+	// The usage seen before the cancellation settles the estimate
+	limiter := c.HTTPClient().Transport.(*rateLimitTransport).limiter
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	require.InDelta(t, 6000-10, limiter.tokens.tokens, 20)
+}
@@ -0,0 +1,282 @@
+package tinfoil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/openai/openai-go/v3/option"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRetryableStatuses are the response statuses retried when
+// RetryPolicy.RetryableStatuses is empty.
+var DefaultRetryableStatuses = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy configures retries of transient failures by the Client's
+// transport. Zero fields select the defaults. Certificate errors are never
+// retried by the policy: the transport re-attests the enclave and retries
+// once on its own, and a certificate error that survives re-attestation is
+// returned immediately.
+//
+// A transport error is only retried when resending cannot duplicate work:
+// the request failed before it was written, or it is idempotent as defined
+// by net/http, with a GET, HEAD, OPTIONS or TRACE method or an
+// Idempotency-Key header. Set that header, e.g. with option.WithHeader, to
+// retry other requests that fail after being sent.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per request, including the
+	// first. Defaults to 3; 1 disables retries.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry, doubled for every
+	// further retry up to MaxBackoff. Each wait is jittered down to half its
+	// length. They default to 500ms and 10s.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// RetryableStatuses lists the response statuses that are retried.
+	// Defaults to DefaultRetryableStatuses.
+	RetryableStatuses []int
+
+	// MaxRetryAfter caps the wait requested by a response's Retry-After
+	// header. A response asking for a longer wait is returned instead of
+	// retried. Defaults to one minute.
+	MaxRetryAfter time.Duration
+}
+
+// WithRetry returns an option that sets ClientOptions.Retry when passed to a
+// Client constructor, such as NewClient.
+func WithRetry(policy RetryPolicy) option.RequestOption {
+	return newClientOption(func(opts *ClientOptions) { opts.Retry = &policy })
+}
+
+// withDefaults returns the policy with zero fields set to their defaults.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 3
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = 500 * time.Millisecond
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = 10 * time.Second
+	}
+	if len(p.RetryableStatuses) == 0 {
+		p.RetryableStatuses = DefaultRetryableStatuses
+	}
+	if p.MaxRetryAfter == 0 {
+		p.MaxRetryAfter = time.Minute
+	}
+	return p
+}
+
+// Validate reports whether the policy is usable.
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 0:
+		return fmt.Errorf("invalid retry policy: max attempts must not be negative, got %d", p.MaxAttempts)
+	case p.InitialBackoff < 0 || p.MaxBackoff < 0 || p.MaxRetryAfter < 0:
+		return fmt.Errorf("invalid retry policy: durations must not be negative")
+	case p.MaxBackoff != 0 && p.InitialBackoff > p.MaxBackoff:
+		return fmt.Errorf("invalid retry policy: initial backoff %s exceeds max backoff %s", p.InitialBackoff, p.MaxBackoff)
+	}
+	return nil
+}
+
+// backoff returns the jittered wait before the given retry, counted from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < retry && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, p.MaxBackoff)
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// retryTransport retries requests that fail with a transient network error
+// or a retryable status.
+type retryTransport struct {
+	policy RetryPolicy
+	next   http.RoundTripper
+}
+
+func newRetryTransport(policy RetryPolicy, next http.RoundTripper) *retryTransport {
+	return &retryTransport{policy: policy.withDefaults(), next: next}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A body that cannot be replayed allows a single attempt only
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		var trace attemptTrace
+		resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace.clientTrace())))
+		if attempt >= t.policy.MaxAttempts {
+			if err != nil && attempt > 1 {
+				return nil, fmt.Errorf("request failed after %d attempts: %w", attempt, err)
+			}
+			return resp, err
+		}
+
+		wait, retry := t.shouldRetry(req, resp, err, attempt, trace.unsent())
+		if !retry {
+			return resp, err
+		}
+		if resp != nil {
+			// Drain so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			log.Debugf("Retrying %s %s after status %d in %s", req.Method, req.URL.Path, resp.StatusCode, wait)
+		} else {
+			log.Debugf("Retrying %s %s after error in %s: %v", req.Method, req.URL.Path, wait, err)
+		}
+
+		if err := sleepContext(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// shouldRetry decides whether an attempt is retried and how long to wait
+// first. unsent reports that the failed attempt never wrote the request.
+func (t *retryTransport) shouldRetry(req *http.Request, resp *http.Response, err error, attempt int, unsent bool) (time.Duration, bool) {
+	if err != nil {
+		if req.Context().Err() != nil || !isTransientError(err) {
+			return 0, false
+		}
+		// The enclave may have acted on a request it received in full
+		if !unsent && !isIdempotent(req) {
+			return 0, false
+		}
+		return t.policy.backoff(attempt), true
+	}
+
+	if !slices.Contains(t.policy.RetryableStatuses, resp.StatusCode) {
+		return 0, false
+	}
+	if wait, ok := retryAfter(resp.Header, time.Now()); ok {
+		if wait > t.policy.MaxRetryAfter {
+			return 0, false
+		}
+		return wait, true
+	}
+	return t.policy.backoff(attempt), true
+}
+
+// isTransientError reports whether a transport error may succeed on retry.
+// Certificate errors are excluded, re-attestation handles those.
+func isTransientError(err error) bool {
+	if isCertificateError(err) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// attemptTrace observes an attempt through httptrace to tell whether it
+// failed before the request was written.
+type attemptTrace struct {
+	connecting atomic.Bool // the transport started obtaining a connection
+	wrote      atomic.Bool // the request was written in full
+}
+
+func (a *attemptTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) { a.connecting.Store(true) },
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				a.wrote.Store(true)
+			}
+		},
+	}
+}
+
+// unsent reports whether the attempt provably never wrote the request. A
+// transport that does not report to httptrace never proves it.
+func (a *attemptTrace) unsent() bool {
+	return a.connecting.Load() && !a.wrote.Load()
+}
+
+// isIdempotent reports whether req may be replayed after it was sent, using
+// the rules of net/http's own retries.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+// retryAfter parses the wait requested by the retry-after-ms or Retry-After
+// headers, the latter either in seconds or as an HTTP date.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
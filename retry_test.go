@@ -0,0 +1,305 @@
+package tinfoil
+
+import (
+	"context"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+	"github.com/stretchr/testify/require"
+	"github.com/tinfoilsh/verifier/client"
+)
+
+var fastRetries = RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+func statusResponse(req *http.Request, status int, header http.Header) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(http.StatusText(status))),
+		Request:    req,
+	}
+}
+
+func TestRetryTransportStatus(t *testing.T) {
+	var bodies []string
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) < 3 {
+			return statusResponse(req, http.StatusServiceUnavailable, nil), nil
+		}
+		return okTransport(req)
+	})
+
+	transport := newRetryTransport(fastRetries, next)
+	req, _ := http.NewRequest(http.MethodPost, "https://enclave.example.com/v1/chat/completions", strings.NewReader("payload"))
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []string{"payload", "payload", "payload"}, bodies)
+}
+
+func TestRetryTransportExhausted(t *testing.T) {
+	var attempts int
+	transport := newRetryTransport(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+		roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			attempts++
+			return nil, syscall.ECONNRESET
+		}))
+
+	req, _ := http.NewRequest(http.MethodGet, "https://enclave.example.com/health", nil)
+	_, err := transport.RoundTrip(req)
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+	require.Contains(t, err.Error(), "after 2 attempts")
+	require.Equal(t, 2, attempts)
+
+	// The last retryable response is returned as is
+	attempts = 0
+	transport.next = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return statusResponse(req, http.StatusBadGateway, nil), nil
+	})
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	require.Equal(t, 2, attempts)
+}
+
+func TestRetryTransportNotRetried(t *testing.T) {
+	tests := []struct {
+		name string
+		resp func(*http.Request) (*http.Response, error)
+	}{
+		{"client error", func(req *http.Request) (*http.Response, error) {
+			return statusResponse(req, http.StatusBadRequest, nil), nil
+		}},
+		{"certificate error", func(*http.Request) (*http.Response, error) {
+			return nil, client.ErrCertMismatch
+		}},
+		{"permanent error", func(*http.Request) (*http.Response, error) {
+			return nil, io.ErrClosedPipe
+		}},
+		{"retry after too long", func(req *http.Request) (*http.Response, error) {
+			return statusResponse(req, http.StatusTooManyRequests, http.Header{"Retry-After": {"3600"}}), nil
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int
+			transport := newRetryTransport(fastRetries, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				attempts++
+				return tt.resp(req)
+			}))
+			req, _ := http.NewRequest(http.MethodGet, "https://enclave.example.com/health", nil)
+			if resp, err := transport.RoundTrip(req); err == nil {
+				resp.Body.Close()
+			}
+			require.Equal(t, 1, attempts)
+		})
+	}
+}
+
+func TestRetryTransportSentRequests(t *testing.T) {
+	// The server drops the connection after reading each request in full
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		received.Add(1)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer server.Close()
+
+	transport := newRetryTransport(fastRetries, &http.Transport{})
+	send := func(method string, header http.Header) {
+		received.Store(0)
+		req, _ := http.NewRequest(method, server.URL, strings.NewReader("payload"))
+		maps.Copy(req.Header, header)
+		_, err := transport.RoundTrip(req)
+		require.Error(t, err)
+	}
+
+	// A request that reached the enclave is not sent again
+	send(http.MethodPost, nil)
+	require.Equal(t, int32(1), received.Load())
+
+	// Unless it is idempotent
+	send(http.MethodGet, nil)
+	require.Equal(t, int32(3), received.Load())
+	send(http.MethodPost, http.Header{"Idempotency-Key": {"key-1"}})
+	require.Equal(t, int32(3), received.Load())
+}
+
+func TestRetryTransportUnsentRequests(t *testing.T) {
+	// Nothing listens on the address, so no attempt writes the request
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	var attempts atomic.Int32
+	transport := newRetryTransport(fastRetries, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts.Add(1)
+		return (&http.Transport{}).RoundTrip(req)
+	}))
+	req, _ := http.NewRequest(http.MethodPost, "http://"+addr, strings.NewReader("payload"))
+	_, err = transport.RoundTrip(req)
+	require.ErrorIs(t, err, syscall.ECONNREFUSED)
+	require.Equal(t, int32(3), attempts.Load())
+}
+
+func TestRetryTransportUnreplayableBody(t *testing.T) {
+	var attempts int
+	transport := newRetryTransport(fastRetries, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return statusResponse(req, http.StatusServiceUnavailable, nil), nil
+	}))
+
+	req, _ := http.NewRequest(http.MethodPost, "https://enclave.example.com/v1/chat/completions", io.NopCloser(strings.NewReader("payload")))
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 1, attempts)
+}
+
+func TestRetryTransportContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	transport := newRetryTransport(RetryPolicy{InitialBackoff: time.Hour, MaxBackoff: time.Hour},
+		roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			cancel()
+			return statusResponse(req, http.StatusServiceUnavailable, nil), nil
+		}))
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://enclave.example.com/health", nil)
+	_, err := transport.RoundTrip(req)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header http.Header
+		want   time.Duration
+		ok     bool
+	}{
+		{http.Header{}, 0, false},
+		{http.Header{"Retry-After": {"2"}}, 2 * time.Second, true},
+		{http.Header{"Retry-After": {"0.5"}}, 500 * time.Millisecond, true},
+		{http.Header{"Retry-After": {now.Add(3 * time.Second).Format(http.TimeFormat)}}, 3 * time.Second, true},
+		{http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, 0, true},
+		{http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"1"}}, 250 * time.Millisecond, true},
+		{http.Header{"Retry-After": {"soon"}}, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.header, now)
+		require.Equal(t, tt.ok, ok, "header %v", tt.header)
+		require.Equal(t, tt.want, got, "header %v", tt.header)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}.withDefaults()
+	for retry, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: 300 * time.Millisecond} {
+		for range 20 {
+			got := p.backoff(retry)
+			require.GreaterOrEqual(t, got, want/2)
+			require.LessOrEqual(t, got, want)
+		}
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	require.NoError(t, RetryPolicy{}.Validate())
+	require.Error(t, RetryPolicy{MaxAttempts: -1}.Validate())
+	require.Error(t, RetryPolicy{InitialBackoff: -time.Second}.Validate())
+	require.Error(t, RetryPolicy{InitialBackoff: time.Minute, MaxBackoff: time.Second}.Validate())
+
+	_, err := NewClientWithOptions(&fakeVerifier{}, ClientOptions{Retry: &RetryPolicy{MaxAttempts: -1}})
+	require.Error(t, err)
+	_, err = NewClientWithVerifier(&fakeVerifier{}, WithRetry(RetryPolicy{MaxAttempts: -1}))
+	require.Error(t, err)
+}
+
+func TestClientRetryWithRotation(t *testing.T) {
+	// Every transport presents a bad certificate: the client re-attests once
+	// and the retry policy does not retry the certificate error
+	var attempts atomic.Int32
+	certErr := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		attempts.Add(1)
+		return nil, client.ErrCertMismatch
+	})
+	v := &fakeVerifier{transports: []http.RoundTripper{certErr, certErr}}
+
+	c, err := NewClientWithOptions(v, ClientOptions{Retry: &fastRetries})
+	require.NoError(t, err)
+
+	_, err = c.HTTPClient().Get("https://enclave.example.com/health")
+	require.ErrorIs(t, err, client.ErrCertMismatch)
+	require.Equal(t, int32(2), attempts.Load())
+	require.Equal(t, 2, v.verifications)
+}
+
+func TestClientRetry(t *testing.T) {
+	var attempts int
+	transport := handlerTransport(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After-Ms", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"}}]}`)
+	})
+
+	c, err := NewClientWithOptions(&fakeVerifier{transports: []http.RoundTripper{transport}},
+		ClientOptions{Retry: &fastRetries})
+	require.NoError(t, err)
+
+	completion, err := c.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "gpt-oss-120b",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hello")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Hi", completion.Choices[0].Message.Content)
+	// openai-go's retries are disabled in favour of the policy
+	require.Equal(t, 2, attempts)
+}
+
+func TestClientRetryOption(t *testing.T) {
+	var attempts int
+	transport := handlerTransport(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	})
+
+	// The option configures the client like ClientOptions.Retry
+	c, err := NewClientWithVerifier(&fakeVerifier{transports: []http.RoundTripper{transport}},
+		option.WithAPIKey("test-key"), WithRetry(fastRetries))
+	require.NoError(t, err)
+
+	var resp *http.Response
+	// Passed to a request, it is a no-op
+	err = c.Get(context.Background(), "models", nil, &resp, WithRetry(RetryPolicy{MaxAttempts: 1}))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 2, attempts)
+}
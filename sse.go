@@ -0,0 +1,222 @@
+package tinfoil
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxEventSize is the default limit on the size of a single event,
+// counting every line received between two dispatches.
+const DefaultMaxEventSize = 16 << 20 // 16 MiB
+
+// ErrEventTooLarge is returned when a single event exceeds the decoder's
+// maximum event size.
+var ErrEventTooLarge = errors.New("event exceeds maximum size")
+
+// SSEEvent is a single dispatched Server-Sent Event.
+type SSEEvent struct {
+	Type string // Event type from the "event" field, "message" if none was given
+	Data string // Data fields joined by newlines
+	ID   string // Last event ID in effect when the event was dispatched
+}
+
+// SSEDecoder reads Server-Sent Events from a stream following the WHATWG
+// HTML specification's event stream interpretation: fields accumulate until a
+// blank line dispatches the event, lines may end in CRLF, LF or CR, and the
+// last event ID and reconnection time persist across events.
+type SSEDecoder struct {
+	scanner      *bufio.Scanner
+	started      bool
+	maxEventSize int
+	eventSize    int // bytes received for the pending event
+
+	// Buffers for the event being accumulated
+	eventType string
+	data      strings.Builder
+	hasData   bool
+
+	lastEventID string
+	retry       time.Duration
+
+	current SSEEvent
+	err     error
+}
+
+// NewSSEDecoder creates a decoder reading an event stream from r with the
+// default maximum event size.
+func NewSSEDecoder(r io.Reader) *SSEDecoder {
+	return NewSSEDecoderSize(r, DefaultMaxEventSize)
+}
+
+// NewSSEDecoderSize creates a decoder reading an event stream from r that
+// fails with ErrEventTooLarge once a single event exceeds maxEventSize bytes.
+// A non-positive size selects DefaultMaxEventSize.
+func NewSSEDecoderSize(r io.Reader, maxEventSize int) *SSEDecoder {
+	if maxEventSize <= 0 {
+		maxEventSize = DefaultMaxEventSize
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanSSELines)
+	// Lines grow the buffer as needed; allow room for the line terminator
+	scanner.Buffer(make([]byte, 0, min(maxEventSize, 64*1024)), maxEventSize+2)
+	return &SSEDecoder{scanner: scanner, maxEventSize: maxEventSize}
+}
+
+// Next advances to the next dispatched event. It returns false at the end of
+// the stream or on a read error. Per the specification, an event that is not
+// terminated by a blank line before the stream ends is discarded.
+func (d *SSEDecoder) Next() bool {
+	if d.err != nil {
+		return false
+	}
+
+	for d.scanner.Scan() {
+		line := d.scanner.Bytes()
+		if !d.started {
+			d.started = true
+			line = bytes.TrimPrefix(line, []byte("\xEF\xBB\xBF"))
+		}
+
+		if len(line) == 0 {
+			if d.dispatch() {
+				return true
+			}
+			continue
+		}
+
+		d.eventSize += len(line)
+		if d.eventSize > d.maxEventSize {
+			d.err = d.tooLarge()
+			return false
+		}
+
+		d.processLine(string(line))
+	}
+
+	d.err = d.scanner.Err()
+	if errors.Is(d.err, bufio.ErrTooLong) {
+		d.err = d.tooLarge()
+	}
+	return false
+}
+
+func (d *SSEDecoder) tooLarge() error {
+	return fmt.Errorf("%w of %d bytes", ErrEventTooLarge, d.maxEventSize)
+}
+
+// Event returns the most recently dispatched event.
+func (d *SSEDecoder) Event() SSEEvent {
+	return d.current
+}
+
+// Err returns the error that stopped decoding, if any.
+func (d *SSEDecoder) Err() error {
+	return d.err
+}
+
+// LastEventID returns the last event ID set by the stream, which a client
+// should send as Last-Event-ID when reconnecting.
+func (d *SSEDecoder) LastEventID() string {
+	return d.lastEventID
+}
+
+// Retry returns the reconnection time most recently set by the stream, or
+// zero if none was set.
+func (d *SSEDecoder) Retry() time.Duration {
+	return d.retry
+}
+
+// processLine applies a single non-blank line to the pending event.
+func (d *SSEDecoder) processLine(line string) {
+	// Lines beginning with a colon are comments
+	if strings.HasPrefix(line, ":") {
+		return
+	}
+
+	field, value, found := strings.Cut(line, ":")
+	if found {
+		value = strings.TrimPrefix(value, " ")
+	}
+
+	switch field {
+	case "event":
+		d.eventType = value
+	case "data":
+		if d.hasData {
+			d.data.WriteByte('\n')
+		}
+		d.data.WriteString(value)
+		d.hasData = true
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			d.lastEventID = value
+		}
+	case "retry":
+		if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+			d.retry = time.Duration(ms) * time.Millisecond
+		}
+	}
+	// Any other field is ignored
+}
+
+// dispatch completes the pending event on a blank line. It reports whether
+// an event was produced; events without data are dropped.
+func (d *SSEDecoder) dispatch() bool {
+	defer func() {
+		d.eventType = ""
+		d.data.Reset()
+		d.hasData = false
+		d.eventSize = 0
+	}()
+
+	if !d.hasData {
+		return false
+	}
+
+	eventType := d.eventType
+	if eventType == "" {
+		eventType = "message"
+	}
+	d.current = SSEEvent{
+		Type: eventType,
+		Data: d.data.String(),
+		ID:   d.lastEventID,
+	}
+	return true
+}
+
+// scanSSELines is a bufio.SplitFunc that splits on CRLF, LF or a lone CR.
+func scanSSELines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		// A CR may be the first half of a CRLF split across reads
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,143 @@
+package tinfoil
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeAll(t *testing.T, stream string) []SSEEvent {
+	t.Helper()
+	decoder := NewSSEDecoder(strings.NewReader(stream))
+	var events []SSEEvent
+	for decoder.Next() {
+		events = append(events, decoder.Event())
+	}
+	if err := decoder.Err(); err != nil {
+		t.Fatalf("Decoder error: %v", err)
+	}
+	return events
+}
+
+func TestSSEDecoderFields(t *testing.T) {
+	stream := "\xEF\xBB\xBFevent: update\n" +
+		"id: 42\n" +
+		"retry: 1500\n" +
+		"data:first line\n" +
+		"data:  second line\n" +
+		"unknown: ignored\n" +
+		": comment\n" +
+		"\n" +
+		"data\n" +
+		"\n" +
+		"id\n" +
+		"data: third\n" +
+		"\n"
+
+	decoder := NewSSEDecoder(strings.NewReader(stream))
+
+	if !decoder.Next() {
+		t.Fatal("Expected first event")
+	}
+	event := decoder.Event()
+	if event.Type != "update" {
+		t.Errorf("Expected type 'update', got '%s'", event.Type)
+	}
+	if event.Data != "first line\n second line" {
+		t.Errorf("Unexpected data: %q", event.Data)
+	}
+	if event.ID != "42" {
+		t.Errorf("Expected ID '42', got '%s'", event.ID)
+	}
+	if decoder.Retry() != 1500*time.Millisecond {
+		t.Errorf("Expected retry 1.5s, got %v", decoder.Retry())
+	}
+
+	// A data field without a value produces an empty event of the default type
+	if !decoder.Next() {
+		t.Fatal("Expected second event")
+	}
+	event = decoder.Event()
+	if event.Type != "message" || event.Data != "" || event.ID != "42" {
+		t.Errorf("Unexpected second event: %+v", event)
+	}
+
+	// An empty id field resets the last event ID
+	if !decoder.Next() {
+		t.Fatal("Expected third event")
+	}
+	if decoder.Event().ID != "" || decoder.LastEventID() != "" {
+		t.Errorf("Expected last event ID to be reset, got %q", decoder.LastEventID())
+	}
+
+	if decoder.Next() {
+		t.Error("Expected end of stream")
+	}
+}
+
+func TestSSEDecoderLineEndings(t *testing.T) {
+	for name, stream := range map[string]string{
+		"LF":   "data: a\ndata: b\n\ndata: c\n\n",
+		"CRLF": "data: a\r\ndata: b\r\n\r\ndata: c\r\n\r\n",
+		"CR":   "data: a\rdata: b\r\rdata: c\r\r",
+	} {
+		t.Run(name, func(t *testing.T) {
+			events := decodeAll(t, stream)
+			if len(events) != 2 {
+				t.Fatalf("Expected 2 events, got %d", len(events))
+			}
+			if events[0].Data != "a\nb" || events[1].Data != "c" {
+				t.Errorf("Unexpected events: %+v", events)
+			}
+		})
+	}
+}
+
+func TestSSEDecoderIgnoresInvalidFields(t *testing.T) {
+	decoder := NewSSEDecoder(strings.NewReader("retry: soon\nid: a\x00b\ndata: x\n\n"))
+	if !decoder.Next() {
+		t.Fatal("Expected an event")
+	}
+	if decoder.Retry() != 0 {
+		t.Errorf("Non-numeric retry should be ignored, got %v", decoder.Retry())
+	}
+	if decoder.LastEventID() != "" {
+		t.Errorf("ID containing NULL should be ignored, got %q", decoder.LastEventID())
+	}
+}
+
+func TestSSEDecoderDiscardsUnterminatedEvent(t *testing.T) {
+	events := decodeAll(t, "data: complete\n\ndata: incomplete\n")
+	if len(events) != 1 || events[0].Data != "complete" {
+		t.Errorf("Expected only the terminated event, got %+v", events)
+	}
+}
+
+func TestSSEDecoderMaxEventSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+	}{
+		{
+			name:   "single long line",
+			stream: "data: " + strings.Repeat("x", 100) + "\n\n",
+		},
+		{
+			name:   "many short lines",
+			stream: strings.Repeat("data: xxxxxxxx\n", 10) + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder := NewSSEDecoderSize(strings.NewReader(tt.stream), 64)
+			if decoder.Next() {
+				t.Fatal("Oversized event should not be dispatched")
+			}
+			if !errors.Is(decoder.Err(), ErrEventTooLarge) {
+				t.Errorf("Expected ErrEventTooLarge, got %v", decoder.Err())
+			}
+		})
+	}
+}
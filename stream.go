@@ -0,0 +1,123 @@
+package tinfoil
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventStream is the plumbing shared by the package's SSE streams. It reads
+// events from a response body and aborts the stream when its context is done
+// or no event arrives within the idle timeout.
+type eventStream struct {
+	reader  io.ReadCloser
+	decoder *SSEDecoder
+	err     error
+	closed  bool
+
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	stopCtx     func() bool
+
+	mu       sync.Mutex
+	abortErr error // set before the reader is closed by cancellation or idle timeout
+}
+
+// newEventStream creates an eventStream reading events of at most
+// maxEventSize bytes from body. Aborting closes body so a blocked read
+// returns promptly.
+func newEventStream(ctx context.Context, body io.ReadCloser, maxEventSize int, idleTimeout time.Duration) *eventStream {
+	s := &eventStream{
+		reader:      body,
+		decoder:     NewSSEDecoderSize(body, maxEventSize),
+		idleTimeout: idleTimeout,
+	}
+
+	s.stopCtx = context.AfterFunc(ctx, func() {
+		s.abort(ctx.Err())
+	})
+	if s.idleTimeout > 0 {
+		s.idleTimer = time.AfterFunc(s.idleTimeout, func() {
+			s.abort(ErrStreamIdle)
+		})
+		s.idleTimer.Stop()
+	}
+	return s
+}
+
+// next returns the payload of the next event, skipping keep-alive events
+// without one. It returns io.EOF at a clean end of the stream and the abort
+// error if the stream was aborted. Ending the stream is left to the caller.
+func (s *eventStream) next() (string, error) {
+	if err := s.aborted(); err != nil {
+		return "", err
+	}
+
+	// Only time spent waiting on the stream counts towards the idle timeout
+	if s.idleTimer != nil {
+		s.idleTimer.Reset(s.idleTimeout)
+		defer s.idleTimer.Stop()
+	}
+
+	for s.decoder.Next() {
+		if data := s.decoder.Event().Data; strings.TrimSpace(data) != "" {
+			return data, nil
+		}
+	}
+
+	// An abort closes the reader, which surfaces here as a read error or EOF
+	if err := s.aborted(); err != nil {
+		return "", err
+	}
+	if err := s.decoder.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+// done reports whether the stream has ended or was closed.
+func (s *eventStream) done() bool {
+	return s.closed || s.err != nil
+}
+
+// abort records why the stream is being stopped and closes the reader to
+// unblock any pending read.
+func (s *eventStream) abort(cause error) {
+	s.mu.Lock()
+	if s.abortErr == nil {
+		s.abortErr = &StreamAbortedError{Cause: cause}
+	}
+	s.mu.Unlock()
+	s.reader.Close()
+}
+
+// aborted returns the abort error, if the stream was aborted.
+func (s *eventStream) aborted() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.abortErr
+}
+
+// finish ends the stream with err, which may be nil.
+func (s *eventStream) finish(err error) {
+	s.err = err
+	s.closed = true
+	s.stopWatching()
+}
+
+// close closes the underlying reader.
+func (s *eventStream) close() error {
+	s.closed = true
+	s.stopWatching()
+	return s.reader.Close()
+}
+
+// stopWatching releases the context and idle timer once the stream is done.
+func (s *eventStream) stopWatching() {
+	s.stopCtx()
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+}
@@ -1,12 +1,14 @@
 package tinfoil
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
@@ -17,38 +19,138 @@ import (
 // reVerifyingTransport wraps an http.RoundTripper and automatically re-verifies
 // attestation on certificate errors, handling server certificate rotation.
 type reVerifyingTransport struct {
-	secureClient *client.SecureClient
-	mu           sync.RWMutex
-	transport    http.RoundTripper
+	verifier Verifier
+	verifyMu sync.Mutex // held across Verify and Transport so they describe the same attestation
+
+	mu          sync.RWMutex
+	transport   http.RoundTripper
+	groundTruth *client.GroundTruth
+	err         error // last re-verification failure, nil once verified
+
+	initMu  sync.Mutex
+	pending *pendingAttestation // initial attestation of lazy and background clients
+
+	generation   uint64    // incremented on every successful attestation
+	verifiedAt   time.Time // time of the last successful attestation
+	lastRotation time.Time // time of the last re-verification after a certificate error
 }
 
+// undiscoveredEnclave is the host of a Client's base URL while its enclave is
+// not yet known, replaced by the attested enclave when sending.
+const undiscoveredEnclave = "enclave.invalid"
+
 func (t *reVerifyingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	t.mu.RLock()
-	transport := t.transport
-	t.mu.RUnlock()
+	transport, status, err := t.ready(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	if req.URL.Host == undiscoveredEnclave {
+		req = req.Clone(req.Context())
+		req.URL.Host = t.verifier.Enclave()
+		req.Host = ""
+	}
 
 	resp, err := transport.RoundTrip(req)
 	if err == nil || !isCertificateError(err) {
-		return resp, err
+		return withResponseAttestation(resp, status), err
 	}
 
-	// Certificate error detected, reinitialize secure client to re-verify attestation
-	newSecureClient := client.NewSecureClient(t.secureClient.Enclave(), t.secureClient.Repo())
-	newHTTPClient, clientErr := newSecureClient.HTTPClient()
-	if clientErr != nil {
+	// Certificate error detected, re-verify attestation
+	newTransport, newStatus, verifyErr := t.reverifySince(status.Generation)
+	if verifyErr != nil {
 		// Re-verification failed, connection is genuinely malicious
 		return nil, err
 	}
 
-	// Re-verification succeeded, update transport and retry
+	// Re-verification succeeded, retry with the newly pinned transport
 	log.Info("Certificate rotation detected, re-verified attestation successfully")
 
+	resp, err = newTransport.RoundTrip(req)
+	return withResponseAttestation(resp, newStatus), err
+}
+
+// reverify attests the enclave from scratch and, on success, swaps in a
+// transport pinned to the newly verified certificate. A failure is recorded
+// so callers can refuse to serve until attestation succeeds again.
+func (t *reVerifyingTransport) reverify() (*client.GroundTruth, http.RoundTripper, error) {
+	t.verifyMu.Lock()
+	defer t.verifyMu.Unlock()
+	return t.reverifyLocked()
+}
+
+// reverifySince re-verifies after a certificate error of a request whose
+// transport was pinned at generation, returning the new transport and the
+// attestation it is pinned to. If another request re-attested in the
+// meantime, its transport is returned instead of attesting again, so a burst
+// of requests hitting a rotated certificate shares a single attestation.
+func (t *reVerifyingTransport) reverifySince(generation uint64) (http.RoundTripper, AttestationStatus, error) {
+	t.verifyMu.Lock()
+	defer t.verifyMu.Unlock()
+
+	t.mu.RLock()
+	transport, status := t.pinnedLocked()
+	t.mu.RUnlock()
+	if status.Generation != generation && transport != nil {
+		return transport, status, nil
+	}
+
+	if _, _, err := t.reverifyLocked(); err != nil {
+		return nil, AttestationStatus{}, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastRotation = time.Now()
+	transport, status = t.pinnedLocked()
+	return transport, status, nil
+}
+
+// pinnedLocked returns the pinned transport and the attestation it is pinned
+// to with t.mu held.
+func (t *reVerifyingTransport) pinnedLocked() (http.RoundTripper, AttestationStatus) {
+	status := AttestationStatus{
+		GroundTruth:  t.groundTruth,
+		Generation:   t.generation,
+		VerifiedAt:   t.verifiedAt,
+		LastRotation: t.lastRotation,
+	}
+	if t.err != nil {
+		status.Error = t.err.Error()
+	}
+	return t.transport, status
+}
+
+// reverifyLocked attests the enclave with verifyMu held.
+func (t *reVerifyingTransport) reverifyLocked() (*client.GroundTruth, http.RoundTripper, error) {
+	groundTruth, transport, err := attest(t.verifier)
+
 	t.mu.Lock()
-	t.secureClient = newSecureClient
-	t.transport = newHTTPClient.Transport
-	t.mu.Unlock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.err = err
+		return nil, nil, err
+	}
+	t.groundTruth = groundTruth
+	t.transport = transport
+	t.err = nil
+	t.generation++
+	t.verifiedAt = time.Now()
+	return groundTruth, transport, nil
+}
 
-	return newHTTPClient.Transport.RoundTrip(req)
+// attest verifies the enclave with v and returns a transport pinned to it
+func attest(v Verifier) (*client.GroundTruth, http.RoundTripper, error) {
+	groundTruth, err := v.Verify()
+	if err != nil {
+		return nil, nil, err
+	}
+	transport, err := v.Transport()
+	if err != nil {
+		return nil, nil, err
+	}
+	return groundTruth, transport, nil
 }
 
 func isCertificateError(err error) bool {
@@ -65,71 +167,297 @@ func isCertificateError(err error) bool {
 		errors.As(err, &certVerifyErr)
 }
 
+// AttestationStatus is a snapshot of the attestation a Client is currently
+// pinned to.
+type AttestationStatus struct {
+	GroundTruth  *client.GroundTruth `json:"ground_truth"`
+	Generation   uint64              `json:"generation"`             // 1 after the initial attestation, incremented on each re-verification; 0 before
+	VerifiedAt   time.Time           `json:"verified_at"`            // Time of the last successful attestation
+	LastRotation time.Time           `json:"last_rotation,omitzero"` // Time of the last certificate rotation, zero if none
+	Error        string              `json:"error,omitempty"`        // Last re-verification failure, empty while attested
+}
+
+type responseAttestationKey struct{}
+
+// withResponseAttestation records on resp the attestation of the transport
+// that served it.
+func withResponseAttestation(resp *http.Response, status AttestationStatus) *http.Response {
+	if resp != nil && resp.Request != nil {
+		resp.Request = resp.Request.WithContext(context.WithValue(resp.Request.Context(), responseAttestationKey{}, status))
+	}
+	return resp
+}
+
+// ResponseAttestation returns the attestation a response received through a
+// Client's transport was served under: the one its connection was pinned to,
+// which a later re-verification does not change. It reports false for other
+// responses.
+func ResponseAttestation(resp *http.Response) (AttestationStatus, bool) {
+	if resp == nil || resp.Request == nil {
+		return AttestationStatus{}, false
+	}
+	status, ok := resp.Request.Context().Value(responseAttestationKey{}).(AttestationStatus)
+	return status, ok
+}
+
+// ClientOptions configures optional behavior of a Client. The zero value
+// matches NewClientWithVerifier. Every constructor also accepts options such
+// as WithRetry among its openaiOpts, which take precedence over the fields
+// set here.
+type ClientOptions struct {
+	// Retry retries transient failures in the client's transport, including
+	// requests made with HTTPClient. Nil disables retries. openai-go's own
+	// retries are disabled when set, unless re-enabled with
+	// option.WithMaxRetries.
+	Retry *RetryPolicy
+
+	// RateLimit delays requests to stay within request and token rates,
+	// shared by every request of the client. Each retry attempt is limited
+	// too. Nil disables rate limiting.
+	RateLimit *RateLimit
+
+	// CircuitBreaker fails requests fast with a *CircuitOpenError while the
+	// enclave is failing, sparing them the connection attempt and any
+	// re-attestation. Open circuits are not retried. Nil disables it.
+	CircuitBreaker *CircuitBreaker
+
+	// Attestation selects when the initial attestation happens. With
+	// AttestLazy and AttestBackground the constructor does not contact the
+	// enclave, or discover the Tinfoil router, and a failed attestation is
+	// returned by the requests that waited on it.
+	Attestation AttestationMode
+
+	// BlockedSearchPolicy selects whether web searches the server blocked
+	// for personal information are only reported, logged as warnings, or
+	// fail the client's web search requests with a *BlockedSearchError.
+	BlockedSearchPolicy BlockedSearchPolicy
+}
+
+// clientOption is an option.RequestOption that sets a ClientOptions field
+// when passed to a constructor. It embeds a no-op RequestOption, so passing
+// it to a request instead has no effect.
+type clientOption struct {
+	option.RequestOption
+	apply func(*ClientOptions)
+}
+
+func newClientOption(apply func(*ClientOptions)) option.RequestOption {
+	return clientOption{RequestOption: option.WithMiddleware(), apply: apply}
+}
+
+// withClientOptions applies the clientOptions in openaiOpts to opts and
+// returns the remaining options for openai-go.
+func withClientOptions(opts ClientOptions, openaiOpts []option.RequestOption) (ClientOptions, []option.RequestOption) {
+	var rest []option.RequestOption
+	for _, o := range openaiOpts {
+		if c, ok := o.(clientOption); ok {
+			c.apply(&opts)
+			continue
+		}
+		rest = append(rest, o)
+	}
+	return opts, rest
+}
+
+// validate reports whether every configured option is usable.
+func (opts ClientOptions) validate() error {
+	if opts.Retry != nil {
+		if err := opts.Retry.Validate(); err != nil {
+			return err
+		}
+	}
+	if opts.RateLimit != nil {
+		if err := opts.RateLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	if opts.CircuitBreaker != nil {
+		if err := opts.CircuitBreaker.Validate(); err != nil {
+			return err
+		}
+	}
+	if opts.BlockedSearchPolicy < BlockedSearchDefault || opts.BlockedSearchPolicy > BlockedSearchFail {
+		return fmt.Errorf("unknown blocked search policy %d", opts.BlockedSearchPolicy)
+	}
+	return nil
+}
+
 // Client wraps the OpenAI client to provide secure inference through Tinfoil
 type Client struct {
 	*openai.Client
-	secureClient  *client.SecureClient
-	httpClient    *http.Client
-	enclave, repo string
+	httpClient *http.Client
+	transport  *reVerifyingTransport
+	breaker    *circuitBreaker // nil without a circuit breaker
+
+	blockedSearchPolicy BlockedSearchPolicy
 }
 
 // NewClientWithParams creates a new secure OpenAI client with explicit enclave and repo parameters
 func NewClientWithParams(enclave, repo string, openaiOpts ...option.RequestOption) (*Client, error) {
-	secureClient := client.NewSecureClient(enclave, repo)
-	return createClientFromSecureClient(secureClient, openaiOpts...)
+	return NewClientWithVerifier(NewSecureClientVerifier(enclave, repo), openaiOpts...)
 }
 
-// NewClient creates a new secure OpenAI client using default parameters
+// NewClient creates a new secure OpenAI client for the Tinfoil router,
+// discovered during the initial attestation. Pass WithAttestation to defer
+// discovery and attestation past the constructor.
 func NewClient(openaiOpts ...option.RequestOption) (*Client, error) {
-	secureClient, err := client.NewDefaultClient()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create secure client: %w", err)
-	}
-	return createClientFromSecureClient(secureClient, openaiOpts...)
+	return NewClientWithOptions(nil, ClientOptions{}, openaiOpts...)
 }
 
-// createClientFromSecureClient is a helper function to create a Client from a SecureClient
-func createClientFromSecureClient(secureClient *client.SecureClient, openaiOpts ...option.RequestOption) (*Client, error) {
-	// Create an HTTP client with our custom transport
-	httpClient, err := secureClient.HTTPClient()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP client: %w", err)
+// NewClientWithVerifier creates a new secure OpenAI client that attests the
+// enclave with v, both initially and whenever the enclave's certificate
+// rotates. This allows alternative verification backends to be plugged in.
+func NewClientWithVerifier(v Verifier, openaiOpts ...option.RequestOption) (*Client, error) {
+	return NewClientWithOptions(v, ClientOptions{}, openaiOpts...)
+}
+
+// NewClientWithOptions is NewClientWithVerifier with optional behavior
+// configured by opts. A nil v verifies the Tinfoil router like NewClient.
+func NewClientWithOptions(v Verifier, opts ClientOptions, openaiOpts ...option.RequestOption) (*Client, error) {
+	opts, openaiOpts = withClientOptions(opts, openaiOpts)
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v = &defaultRouterVerifier{}
+	}
+
+	switch opts.Attestation {
+	case AttestEager:
+		groundTruth, transport, err := attest(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP client: %w", err)
+		}
+		return newClient(v, groundTruth, transport, opts, openaiOpts...), nil
+	case AttestLazy:
+		return newClient(v, nil, nil, opts, openaiOpts...), nil
+	case AttestBackground:
+		c := newClient(v, nil, nil, opts, openaiOpts...)
+		c.transport.startAttestation()
+		return c, nil
 	}
+	return nil, fmt.Errorf("unknown attestation mode %s", opts.Attestation)
+}
 
+// newClient creates a Client whose requests go through transport, which must
+// be pinned to the enclave attested by groundTruth. A nil transport defers
+// the initial attestation to the first request.
+func newClient(v Verifier, groundTruth *client.GroundTruth, transport http.RoundTripper, opts ClientOptions, openaiOpts ...option.RequestOption) *Client {
 	// Wrap with re-verifying transport to handle certificate rotation
 	reVerifying := &reVerifyingTransport{
-		secureClient: secureClient,
-		transport:    httpClient.Transport,
+		verifier:    v,
+		transport:   transport,
+		groundTruth: groundTruth,
+	}
+	if transport != nil {
+		reVerifying.generation = 1
+		reVerifying.verifiedAt = time.Now()
+	}
+	httpClient := &http.Client{Transport: reVerifying}
+
+	if opts.RateLimit != nil {
+		httpClient.Transport = &rateLimitTransport{limiter: newRateLimiter(*opts.RateLimit), next: httpClient.Transport}
+	}
+	var breaker *circuitBreaker
+	if opts.CircuitBreaker != nil {
+		// Outside the rate limiter, so failing fast spends no rate budget
+		breaker = newCircuitBreaker(*opts.CircuitBreaker, v.Enclave)
+		httpClient.Transport = &circuitBreakerTransport{breaker: breaker, next: httpClient.Transport}
+	}
+	if opts.Retry != nil {
+		// Retries wrap everything else. Re-verification retries a certificate
+		// error once itself, and neither certificate errors nor open circuits
+		// are retried by the policy
+		httpClient.Transport = newRetryTransport(*opts.Retry, httpClient.Transport)
+		// Avoid multiplying attempts with openai-go's own retries, unless
+		// the caller sets them explicitly
+		openaiOpts = append([]option.RequestOption{option.WithMaxRetries(0)}, openaiOpts...)
 	}
-	httpClient.Transport = reVerifying
 
-	// Add our HTTP client and base URL to the options
+	// Add our HTTP client and base URL to the options. An enclave that is
+	// only known once attested is filled in by the re-verifying transport
+	host := v.Enclave()
+	if host == "" {
+		host = undiscoveredEnclave
+	}
 	allOpts := append(openaiOpts,
 		option.WithHTTPClient(httpClient),
-		option.WithBaseURL(fmt.Sprintf("https://%s/v1/", secureClient.Enclave())),
+		option.WithBaseURL(fmt.Sprintf("https://%s/v1/", host)),
 	)
 
 	openaiClient := openai.NewClient(allOpts...)
 	return &Client{
-		Client:       &openaiClient,
-		secureClient: secureClient,
-		httpClient:   httpClient,
-		enclave:      secureClient.Enclave(),
-		repo:         secureClient.Repo(),
-	}, nil
+		Client:     &openaiClient,
+		httpClient: httpClient,
+		transport:  reVerifying,
+		breaker:    breaker,
+
+		blockedSearchPolicy: opts.BlockedSearchPolicy,
+	}
 }
 
+// Enclave returns the host of the enclave, which is empty until a client of
+// the Tinfoil router has attested it.
 func (c *Client) Enclave() string {
-	return c.enclave
+	return c.transport.verifier.Enclave()
 }
 
 func (c *Client) Repo() string {
-	return c.repo
+	return c.transport.verifier.Repo()
 }
 
-// Verify re-verifies the enclave attestation and returns the ground truth
+// Verify re-verifies the enclave attestation, pins subsequent requests to the
+// newly attested certificate and returns the ground truth
 func (c *Client) Verify() (*client.GroundTruth, error) {
-	return c.secureClient.Verify()
+	groundTruth, _, err := c.transport.reverify()
+	if err != nil {
+		return nil, err
+	}
+	return groundTruth, nil
+}
+
+// CircuitState returns the state of the client's circuit breaker, which is
+// always CircuitClosed without one.
+func (c *Client) CircuitState() CircuitState {
+	if c.breaker == nil {
+		return CircuitClosed
+	}
+	return c.breaker.State()
+}
+
+// WaitForAttestation blocks until the initial attestation has succeeded,
+// starting it if the client attests lazily and has not yet done so. It
+// returns immediately for clients that attested eagerly.
+func (c *Client) WaitForAttestation(ctx context.Context) error {
+	_, _, err := c.transport.ready(ctx)
+	return err
+}
+
+// AttestationErr returns the error from the most recent failed re-verification,
+// or nil if requests are pinned to a successfully attested enclave.
+func (c *Client) AttestationErr() error {
+	c.transport.mu.RLock()
+	defer c.transport.mu.RUnlock()
+	return c.transport.err
+}
+
+// Attestation returns a snapshot of the client's current attestation state
+// without contacting the enclave.
+func (c *Client) Attestation() AttestationStatus {
+	c.transport.mu.RLock()
+	defer c.transport.mu.RUnlock()
+	_, status := c.transport.pinnedLocked()
+	return status
+}
+
+// GroundTruth returns the ground truth from the most recent successful
+// attestation, including any re-verification after certificate rotation, or
+// nil before the initial attestation. Unlike Verify, it does not contact the
+// enclave.
+func (c *Client) GroundTruth() *client.GroundTruth {
+	c.transport.mu.RLock()
+	defer c.transport.mu.RUnlock()
+	return c.transport.groundTruth
 }
 
 // HTTPClient returns the underlying HTTP client that is configured with
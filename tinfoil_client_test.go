@@ -4,9 +4,15 @@ import (
 	"context"
 	"crypto/x509"
 	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
@@ -264,3 +270,250 @@ func TestIsCertificateError(t *testing.T) {
 		})
 	}
 }
+
+// fakeVerifier attests successfully with a new pinned transport on every
+// Verify, unless err is set.
+type fakeVerifier struct {
+	verifications int
+	err           error
+	transports    []http.RoundTripper
+}
+
+func (v *fakeVerifier) Enclave() string { return "enclave.example.com" }
+func (v *fakeVerifier) Repo() string    { return "tinfoilsh/example" }
+
+func (v *fakeVerifier) Verify() (*client.GroundTruth, error) {
+	if v.err != nil {
+		return nil, v.err
+	}
+	v.verifications++
+	return &client.GroundTruth{Digest: strings.Repeat("a", v.verifications)}, nil
+}
+
+func (v *fakeVerifier) Transport() (http.RoundTripper, error) {
+	return v.transports[v.verifications-1], nil
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func okTransport(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok")), Request: req}, nil
+}
+
+func TestNewClientWithVerifier(t *testing.T) {
+	rotated := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, client.ErrCertMismatch
+	})
+	v := &fakeVerifier{transports: []http.RoundTripper{rotated, roundTripFunc(okTransport)}}
+
+	c, err := NewClientWithVerifier(v)
+	require.NoError(t, err)
+	require.Equal(t, "enclave.example.com", c.Enclave())
+	require.Equal(t, "tinfoilsh/example", c.Repo())
+	require.Equal(t, "a", c.GroundTruth().Digest)
+
+	// A certificate error re-verifies through the same verifier and retries
+	resp, err := c.HTTPClient().Get("https://enclave.example.com/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 2, v.verifications)
+	require.Equal(t, "aa", c.GroundTruth().Digest)
+	require.Equal(t, uint64(2), c.Attestation().Generation)
+}
+
+func TestConcurrentCertificateErrorsReverifyOnce(t *testing.T) {
+	const requests = 10
+	var arrived sync.WaitGroup
+	arrived.Add(requests)
+	// Every request fails on the rotated certificate before any re-verifies
+	rotated := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		arrived.Done()
+		arrived.Wait()
+		return nil, client.ErrCertMismatch
+	})
+	v := &fakeVerifier{transports: []http.RoundTripper{rotated, roundTripFunc(okTransport)}}
+
+	c, err := NewClientWithVerifier(v)
+	require.NoError(t, err)
+
+	errs := make(chan error, requests)
+	for range requests {
+		go func() {
+			resp, err := c.HTTPClient().Get("https://enclave.example.com/health")
+			if err == nil {
+				resp.Body.Close()
+			}
+			errs <- err
+		}()
+	}
+	for range requests {
+		require.NoError(t, <-errs)
+	}
+
+	require.Equal(t, 2, v.verifications)
+	require.Equal(t, uint64(2), c.Attestation().Generation)
+}
+
+func TestResponseAttestation(t *testing.T) {
+	var c *Client
+	// The enclave is re-attested while the first response is being served
+	reattesting := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if _, err := c.Verify(); err != nil {
+			return nil, err
+		}
+		return okTransport(req)
+	})
+	rotated := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, client.ErrCertMismatch
+	})
+	v := &fakeVerifier{transports: []http.RoundTripper{reattesting, rotated, roundTripFunc(okTransport)}}
+
+	var err error
+	c, err = NewClientWithVerifier(v)
+	require.NoError(t, err)
+
+	resp, err := c.HTTPClient().Get("https://enclave.example.com/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	status, ok := ResponseAttestation(resp)
+	require.True(t, ok)
+	require.Equal(t, uint64(1), status.Generation)
+	require.Equal(t, "a", status.GroundTruth.Digest)
+	require.Equal(t, uint64(2), c.Attestation().Generation)
+
+	// A response retried after a rotation reports the new attestation
+	resp, err = c.HTTPClient().Get("https://enclave.example.com/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	status, ok = ResponseAttestation(resp)
+	require.True(t, ok)
+	require.Equal(t, uint64(3), status.Generation)
+	require.Equal(t, "aaa", status.GroundTruth.Digest)
+	require.False(t, status.LastRotation.IsZero())
+
+	_, ok = ResponseAttestation(&http.Response{Request: httptest.NewRequest(http.MethodGet, "/", nil)})
+	require.False(t, ok)
+}
+
+func TestNewClientWithVerifierError(t *testing.T) {
+	verifyErr := errors.New("attestation rejected")
+
+	_, err := NewClientWithVerifier(&fakeVerifier{err: verifyErr})
+	require.ErrorIs(t, err, verifyErr)
+}
+
+func TestNewClientDefersRouterDiscovery(t *testing.T) {
+	var discoveries int
+	discoverErr := errors.New("router service unavailable")
+	defer func(discover func() (*client.SecureClient, error)) { discoverRouter = discover }(discoverRouter)
+	discoverRouter = func() (*client.SecureClient, error) {
+		discoveries++
+		return nil, discoverErr
+	}
+
+	// A lazy client does not look for a router until it is needed
+	c, err := NewClient(WithAttestation(AttestLazy))
+	require.NoError(t, err)
+	require.Zero(t, discoveries)
+	require.Empty(t, c.Enclave())
+	require.Equal(t, defaultRouterRepo, c.Repo())
+
+	require.ErrorIs(t, c.WaitForAttestation(context.Background()), discoverErr)
+	require.Equal(t, 1, discoveries)
+
+	// An eager client discovers the router in the constructor
+	_, err = NewClientWithOptions(nil, ClientOptions{})
+	require.ErrorIs(t, err, discoverErr)
+	require.Equal(t, 2, discoveries)
+}
+
+// discoveringVerifier only knows its enclave once verified, like the
+// verifier of the Tinfoil router.
+type discoveringVerifier struct {
+	fakeVerifier
+	discovered atomic.Bool
+}
+
+func (v *discoveringVerifier) Enclave() string {
+	if v.discovered.Load() {
+		return "enclave.example.com"
+	}
+	return ""
+}
+
+func (v *discoveringVerifier) Verify() (*client.GroundTruth, error) {
+	groundTruth, err := v.fakeVerifier.Verify()
+	if err == nil {
+		v.discovered.Store(true)
+	}
+	return groundTruth, err
+}
+
+func TestUndiscoveredEnclave(t *testing.T) {
+	var host string
+	v := &discoveringVerifier{fakeVerifier: fakeVerifier{transports: []http.RoundTripper{
+		roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			host = req.URL.Host
+			return okTransport(req)
+		}),
+	}}}
+
+	c, err := NewClientWithOptions(v, ClientOptions{Attestation: AttestLazy})
+	require.NoError(t, err)
+
+	// Requests reach the enclave discovered by the attestation they wait on
+	var resp *http.Response
+	require.NoError(t, c.Get(context.Background(), "models", nil, &resp))
+	resp.Body.Close()
+	require.Equal(t, "enclave.example.com", host)
+	require.Equal(t, "enclave.example.com", c.Enclave())
+}
+
+// overlapVerifier records whether a Verify started before the transport of
+// the previous one was taken.
+type overlapVerifier struct {
+	mu         sync.Mutex
+	unpinned   bool
+	overlapped bool
+}
+
+func (v *overlapVerifier) Enclave() string { return "enclave.example.com" }
+func (v *overlapVerifier) Repo() string    { return "tinfoilsh/example" }
+
+func (v *overlapVerifier) Verify() (*client.GroundTruth, error) {
+	v.mu.Lock()
+	v.overlapped = v.overlapped || v.unpinned
+	v.unpinned = true
+	v.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	return &client.GroundTruth{}, nil
+}
+
+func (v *overlapVerifier) Transport() (http.RoundTripper, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.unpinned = false
+	return roundTripFunc(okTransport), nil
+}
+
+func TestConcurrentVerifyIsSerialized(t *testing.T) {
+	v := &overlapVerifier{}
+	c, err := NewClientWithVerifier(v)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Verify()
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.False(t, v.overlapped, "transport and ground truth may belong to different attestations")
+}
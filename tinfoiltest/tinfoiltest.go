@@ -0,0 +1,252 @@
+// Package tinfoiltest provides a fake attested enclave for testing code that
+// depends on tinfoil.Client without network access.
+//
+// An Enclave is an httptest TLS server standing in for a Tinfoil enclave. Its
+// clients are attested by a stub verifier that accepts whatever certificate
+// the server currently presents and pins requests to it, so the full client
+// stack, including re-verification on certificate rotation, runs offline.
+package tinfoiltest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3/option"
+	"github.com/tinfoilsh/tinfoil-go"
+	"github.com/tinfoilsh/verifier/client"
+)
+
+// DefaultRepo and DefaultDigest are reported by an Enclave's attestation
+// unless overridden.
+const (
+	DefaultRepo   = "tinfoilsh/tinfoiltest"
+	DefaultDigest = "0000000000000000000000000000000000000000000000000000000000000000"
+)
+
+// ErrAttestationFailed is a convenience error for FailAttestation.
+var ErrAttestationFailed = errors.New("tinfoiltest: attestation failed")
+
+// Enclave is a fake enclave serving HTTPS with a rotatable certificate.
+type Enclave struct {
+	// Server is the underlying test server.
+	Server *httptest.Server
+
+	// Repo and Digest are reported in the ground truth of every attestation.
+	Repo   string
+	Digest string
+
+	mu           sync.Mutex
+	cert         tls.Certificate
+	fingerprint  string
+	attestErr    error
+	attestations int
+}
+
+// NewEnclave starts a fake enclave serving handler over TLS. The caller
+// should call Close when finished.
+func NewEnclave(handler http.Handler) *Enclave {
+	e := &Enclave{
+		Repo:   DefaultRepo,
+		Digest: DefaultDigest,
+	}
+	e.setCertificate(newCertificate())
+
+	e.Server = httptest.NewUnstartedServer(handler)
+	e.Server.TLS = &tls.Config{
+		// Consulted on every handshake so rotation takes effect immediately
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			return &tls.Config{
+				Certificates: []tls.Certificate{e.cert},
+				NextProtos:   []string{"http/1.1"},
+			}, nil
+		},
+	}
+	e.Server.StartTLS()
+	return e
+}
+
+// Close shuts down the enclave.
+func (e *Enclave) Close() {
+	e.Server.Close()
+}
+
+// Host returns the enclave's host:port, as used by tinfoil.Client.Enclave.
+func (e *Enclave) Host() string {
+	return e.Server.Listener.Addr().String()
+}
+
+// NewClient returns a tinfoil.Client attested against this enclave by a new
+// stub verifier.
+func (e *Enclave) NewClient(openaiOpts ...option.RequestOption) (*tinfoil.Client, error) {
+	return tinfoil.NewClientWithVerifier(e.Verifier(), openaiOpts...)
+}
+
+// Verifier returns a stub tinfoil.Verifier that accepts whatever certificate
+// the enclave serves at the time of each Verify.
+func (e *Enclave) Verifier() tinfoil.Verifier {
+	return &stubVerifier{enclave: e}
+}
+
+// RotateCertificate replaces the server certificate and drops open
+// connections, so clients hit a pinning mismatch on their next request and
+// must re-verify.
+func (e *Enclave) RotateCertificate() {
+	e.setCertificate(newCertificate())
+	e.Server.CloseClientConnections()
+}
+
+// FailAttestation makes every subsequent attestation fail with err, as if the
+// enclave could not be verified. Pass nil to restore successful attestation.
+func (e *Enclave) FailAttestation(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.attestErr = err
+}
+
+// Attestations returns how many times clients have attested this enclave.
+func (e *Enclave) Attestations() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.attestations
+}
+
+// GroundTruth returns the ground truth an attestation would currently report.
+func (e *Enclave) GroundTruth() *client.GroundTruth {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.groundTruthLocked()
+}
+
+func (e *Enclave) groundTruthLocked() *client.GroundTruth {
+	return &client.GroundTruth{
+		EnclaveHost:        e.Host(),
+		TLSPublicKey:       e.fingerprint,
+		Digest:             e.Digest,
+		CodeFingerprint:    e.Digest,
+		EnclaveFingerprint: e.Digest,
+	}
+}
+
+func (e *Enclave) setCertificate(cert tls.Certificate) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cert = cert
+	e.fingerprint = publicKeyFingerprint(cert.Leaf)
+}
+
+// stubVerifier attests an Enclave by trusting the certificate it currently
+// serves, as a real verifier would after checking the enclave's attestation.
+type stubVerifier struct {
+	enclave *Enclave
+
+	mu          sync.Mutex
+	fingerprint string
+}
+
+func (v *stubVerifier) Enclave() string {
+	return v.enclave.Host()
+}
+
+func (v *stubVerifier) Repo() string {
+	return v.enclave.Repo
+}
+
+func (v *stubVerifier) Verify() (*client.GroundTruth, error) {
+	e := v.enclave
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.attestations++
+	if e.attestErr != nil {
+		return nil, e.attestErr
+	}
+	groundTruth := e.groundTruthLocked()
+
+	v.mu.Lock()
+	v.fingerprint = groundTruth.TLSPublicKey
+	v.mu.Unlock()
+	return groundTruth, nil
+}
+
+func (v *stubVerifier) Transport() (http.RoundTripper, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.fingerprint == "" {
+		return nil, tinfoil.ErrNotVerified
+	}
+	return pinnedTransport(v.fingerprint), nil
+}
+
+// pinnedTransport returns a transport that only completes TLS handshakes with
+// a server presenting a certificate with the given public key fingerprint.
+func pinnedTransport(fingerprint string) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		// Trust is established by the pin below rather than a CA chain
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return client.ErrNoTLS
+			}
+			if publicKeyFingerprint(cs.PeerCertificates[0]) != fingerprint {
+				return client.ErrCertMismatch
+			}
+			return nil
+		},
+	}
+	return transport
+}
+
+func publicKeyFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// newCertificate generates a self-signed certificate for the loopback address.
+func newCertificate() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("tinfoiltest: failed to generate key: %v", err))
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "tinfoiltest enclave"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(fmt.Sprintf("tinfoiltest: failed to create certificate: %v", err))
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(fmt.Sprintf("tinfoiltest: failed to parse certificate: %v", err))
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+}
@@ -0,0 +1,81 @@
+package tinfoiltest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+	"github.com/stretchr/testify/require"
+)
+
+func newModelsEnclave(t *testing.T) *Enclave {
+	e := NewEnclave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/models", r.URL.Path)
+		require.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"test-model","object":"model","created":0,"owned_by":"tinfoil"}]}`))
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func TestEnclaveClient(t *testing.T) {
+	e := newModelsEnclave(t)
+
+	c, err := e.NewClient(option.WithAPIKey("test-key"))
+	require.NoError(t, err)
+	require.Equal(t, e.Host(), c.Enclave())
+	require.Equal(t, DefaultRepo, c.Repo())
+	require.Equal(t, e.GroundTruth(), c.GroundTruth())
+
+	models, err := c.Models.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, models.Data, 1)
+	require.Equal(t, "test-model", models.Data[0].ID)
+	require.Equal(t, 1, e.Attestations())
+}
+
+func TestEnclaveRotateCertificate(t *testing.T) {
+	e := newModelsEnclave(t)
+
+	c, err := e.NewClient(option.WithAPIKey("test-key"))
+	require.NoError(t, err)
+	before := c.GroundTruth().TLSPublicKey
+
+	e.RotateCertificate()
+
+	_, err = c.Models.List(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, e.Attestations())
+
+	status := c.Attestation()
+	require.Equal(t, uint64(2), status.Generation)
+	require.False(t, status.LastRotation.IsZero())
+	require.NotEqual(t, before, status.GroundTruth.TLSPublicKey)
+	require.NoError(t, c.AttestationErr())
+}
+
+func TestEnclaveFailAttestation(t *testing.T) {
+	e := newModelsEnclave(t)
+
+	e.FailAttestation(ErrAttestationFailed)
+	_, err := e.NewClient()
+	require.ErrorIs(t, err, ErrAttestationFailed)
+	e.FailAttestation(nil)
+
+	c, err := e.NewClient(option.WithAPIKey("test-key"), option.WithMaxRetries(0))
+	require.NoError(t, err)
+
+	// A rotated certificate that cannot be re-attested must be rejected
+	e.FailAttestation(ErrAttestationFailed)
+	e.RotateCertificate()
+
+	_, err = c.Models.List(context.Background())
+	require.Error(t, err)
+	var apiErr *openai.Error
+	require.False(t, errors.As(err, &apiErr), "request must fail before reaching the server")
+	require.ErrorIs(t, c.AttestationErr(), ErrAttestationFailed)
+}
@@ -0,0 +1,167 @@
+package tinfoil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/tinfoilsh/verifier/client"
+)
+
+// Verifier attests an enclave and produces transports pinned to it.
+// Client uses a Verifier for the initial attestation and again whenever a
+// certificate error suggests the enclave's certificate has rotated.
+// A Client serializes its Verify and Transport calls so the transport always
+// belongs to the preceding Verify; sharing a Verifier between Clients loses
+// that guarantee.
+// Implementations must be safe for concurrent use.
+type Verifier interface {
+	// Enclave returns the host of the enclave being verified.
+	Enclave() string
+	// Repo returns the repository whose signed releases the enclave must run.
+	Repo() string
+	// Verify performs a fresh attestation and returns the verified ground truth.
+	Verify() (*client.GroundTruth, error)
+	// Transport returns an http.RoundTripper that only completes requests to
+	// the enclave attested by the most recent successful Verify.
+	Transport() (http.RoundTripper, error)
+}
+
+// ErrNotVerified is returned by a Verifier's Transport before the enclave has
+// been successfully verified.
+var ErrNotVerified = errors.New("enclave has not been verified")
+
+// SecureClientVerifier is the default Verifier, backed by the Tinfoil
+// verifier's SecureClient. Each Verify attests the enclave from scratch.
+type SecureClientVerifier struct {
+	enclave, repo string
+
+	mu           sync.RWMutex
+	secureClient *client.SecureClient // last successfully verified client
+}
+
+var _ Verifier = (*SecureClientVerifier)(nil)
+
+// NewSecureClientVerifier creates a verifier for the enclave running code
+// from the given repo.
+func NewSecureClientVerifier(enclave, repo string) *SecureClientVerifier {
+	return &SecureClientVerifier{enclave: enclave, repo: repo}
+}
+
+// newSecureClientVerifierFrom wraps a SecureClient, which may already be verified.
+func newSecureClientVerifierFrom(secureClient *client.SecureClient) *SecureClientVerifier {
+	v := NewSecureClientVerifier(secureClient.Enclave(), secureClient.Repo())
+	if secureClient.GroundTruth() != nil {
+		v.secureClient = secureClient
+	}
+	return v
+}
+
+func (v *SecureClientVerifier) Enclave() string {
+	return v.enclave
+}
+
+func (v *SecureClientVerifier) Repo() string {
+	return v.repo
+}
+
+// Verify attests the enclave with a new SecureClient, replacing the previous
+// attestation only on success.
+func (v *SecureClientVerifier) Verify() (*client.GroundTruth, error) {
+	secureClient := client.NewSecureClient(v.enclave, v.repo)
+	groundTruth, err := secureClient.Verify()
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	v.secureClient = secureClient
+	v.mu.Unlock()
+	return groundTruth, nil
+}
+
+// Transport returns a transport that only accepts TLS connections presenting
+// the attested certificate.
+func (v *SecureClientVerifier) Transport() (http.RoundTripper, error) {
+	v.mu.RLock()
+	secureClient := v.secureClient
+	v.mu.RUnlock()
+
+	if secureClient == nil {
+		return nil, ErrNotVerified
+	}
+	httpClient, err := secureClient.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return httpClient.Transport, nil
+}
+
+// defaultRouterRepo is the repository whose signed releases the Tinfoil
+// router runs.
+const defaultRouterRepo = "tinfoilsh/confidential-model-router"
+
+// discoverRouter finds and verifies a Tinfoil router, falling back to an
+// unverified client for the default inference host.
+var discoverRouter = client.NewDefaultClient
+
+// defaultRouterVerifier verifies a Tinfoil router. Its first successful
+// Verify discovers the router, and later ones re-verify the same router.
+// Enclave returns "" until then.
+type defaultRouterVerifier struct {
+	mu     sync.RWMutex
+	router *SecureClientVerifier // nil before discovery
+}
+
+var _ Verifier = (*defaultRouterVerifier)(nil)
+
+func (v *defaultRouterVerifier) Enclave() string {
+	if router := v.current(); router != nil {
+		return router.Enclave()
+	}
+	return ""
+}
+
+func (v *defaultRouterVerifier) Repo() string {
+	return defaultRouterRepo
+}
+
+// Verify discovers a router on first use, and re-verifies it afterwards.
+func (v *defaultRouterVerifier) Verify() (*client.GroundTruth, error) {
+	if router := v.current(); router != nil {
+		return router.Verify()
+	}
+
+	secureClient, err := discoverRouter()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover router: %w", err)
+	}
+	router := newSecureClientVerifierFrom(secureClient)
+	groundTruth := secureClient.GroundTruth()
+	if groundTruth == nil {
+		// The fallback client has not been verified yet
+		if groundTruth, err = router.Verify(); err != nil {
+			return nil, err
+		}
+	}
+
+	v.mu.Lock()
+	v.router = router
+	v.mu.Unlock()
+	return groundTruth, nil
+}
+
+func (v *defaultRouterVerifier) Transport() (http.RoundTripper, error) {
+	router := v.current()
+	if router == nil {
+		return nil, ErrNotVerified
+	}
+	return router.Transport()
+}
+
+func (v *defaultRouterVerifier) current() *SecureClientVerifier {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.router
+}
@@ -1,20 +1,21 @@
 package tinfoil
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"strings"
+	"time"
 )
 
 // WebSearchCall represents a web search event emitted during streaming.
 // These events are emitted before chat completion chunks and track search progress.
 type WebSearchCall struct {
-	Type   string           `json:"type"`   // Always "web_search_call"
-	ID     string           `json:"id"`     // Unique identifier (e.g., "ws_abc123")
-	Status string           `json:"status"` // "in_progress", "completed", "failed", or "blocked"
+	Type   string           `json:"type"`             // Always "web_search_call"
+	ID     string           `json:"id"`               // Unique identifier (e.g., "ws_abc123")
+	Status WebSearchStatus  `json:"status"`           // "in_progress", "completed", "failed", or "blocked"
 	Reason string           `json:"reason,omitempty"` // Present when status is "failed" or "blocked"
 	Action *WebSearchAction `json:"action,omitempty"`
 }
@@ -37,6 +38,24 @@ type URLCitation struct {
 	URL           string `json:"url"`
 	Content       string `json:"content,omitempty"`
 	PublishedDate string `json:"published_date,omitempty"`
+
+	// Span of the cited text in the message content, in characters (runes).
+	// Present only when the server provides it; see Span.
+	StartIndex *int `json:"start_index,omitempty"`
+	EndIndex   *int `json:"end_index,omitempty"`
+}
+
+// Span returns the character offsets of the cited text in the message
+// content. ok is false if the server did not provide a valid span.
+func (c URLCitation) Span() (start, end int, ok bool) {
+	if c.StartIndex == nil || c.EndIndex == nil {
+		return 0, 0, false
+	}
+	start, end = *c.StartIndex, *c.EndIndex
+	if start < 0 || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
 }
 
 // ReasoningItem represents a reasoning step from the agent model.
@@ -59,19 +78,61 @@ type BlockedSearch struct {
 	Reason string `json:"reason,omitempty"`
 }
 
+// ToolCall is a function call requested by the model.
+type ToolCall struct {
+	Index    int              `json:"index"`          // Position in the message's tool calls; streaming only
+	ID       string           `json:"id,omitempty"`   // Present on the first delta of a streamed call
+	Type     string           `json:"type,omitempty"` // "function"
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction is the function and arguments of a tool call. When
+// streaming, Arguments arrives in fragments that must be concatenated.
+type ToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// Usage reports the tokens used by a request. When streaming it is sent in a
+// final chunk if the request sets stream_options.include_usage.
+type Usage struct {
+	PromptTokens            int64                    `json:"prompt_tokens"`
+	CompletionTokens        int64                    `json:"completion_tokens"`
+	TotalTokens             int64                    `json:"total_tokens"`
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+// PromptTokensDetails breaks down the prompt tokens.
+type PromptTokensDetails struct {
+	CachedTokens int64 `json:"cached_tokens"`
+}
+
+// CompletionTokensDetails breaks down the completion tokens.
+type CompletionTokensDetails struct {
+	ReasoningTokens int64 `json:"reasoning_tokens"`
+}
+
 // WebSearchDelta extends the standard delta with web search metadata.
 // These fields appear in the metadata chunk before content chunks.
 type WebSearchDelta struct {
+	Role            string          `json:"role,omitempty"`
 	Content         string          `json:"content,omitempty"`
+	Refusal         string          `json:"refusal,omitempty"`
+	ToolCalls       []ToolCall      `json:"tool_calls,omitempty"`
 	Annotations     []Annotation    `json:"annotations,omitempty"`
 	SearchReasoning string          `json:"search_reasoning,omitempty"`
 	ReasoningItems  []ReasoningItem `json:"reasoning_items,omitempty"`
+	BlockedSearches []BlockedSearch `json:"blocked_searches,omitempty"`
 }
 
 // WebSearchMessage extends the standard message with web search metadata.
 // Used in non-streaming responses.
 type WebSearchMessage struct {
+	Role            string          `json:"role,omitempty"`
 	Content         string          `json:"content"`
+	Refusal         string          `json:"refusal,omitempty"`
+	ToolCalls       []ToolCall      `json:"tool_calls,omitempty"`
 	Annotations     []Annotation    `json:"annotations,omitempty"`
 	SearchReasoning string          `json:"search_reasoning,omitempty"`
 	ReasoningItems  []ReasoningItem `json:"reasoning_items,omitempty"`
@@ -83,13 +144,58 @@ type WebSearchMessage struct {
 type WebSearchStreamEvent struct {
 	// WebSearchCall fields (present when Type == "web_search_call")
 	Type   string           `json:"type,omitempty"`
-	ID     string           `json:"id,omitempty"`
-	Status string           `json:"status,omitempty"`
+	ID     string           `json:"id,omitempty"` // Search ID, or the chunk ID for chat completion chunks
+	Status WebSearchStatus  `json:"status,omitempty"`
 	Reason string           `json:"reason,omitempty"`
 	Action *WebSearchAction `json:"action,omitempty"`
 
 	// Chat completion chunk fields (present when Type is empty or not "web_search_call")
+	Object  string            `json:"object,omitempty"` // "chat.completion.chunk"
+	Model   string            `json:"model,omitempty"`
+	Created int64             `json:"created,omitempty"` // Unix timestamp in seconds
 	Choices []WebSearchChoice `json:"choices,omitempty"`
+	Usage   *Usage            `json:"usage,omitempty"` // Only in the final chunk when usage is requested
+
+	// Raw is the event's JSON as received, set for events read from a stream.
+	Raw json.RawMessage `json:"-"`
+
+	// Decoded holds the result of the decoder registered for the event's kind
+	// with WebSearchStream.RegisterDecoder, if any.
+	Decoded any `json:"-"`
+}
+
+// WebSearchEventKind identifies the kind of a stream event.
+type WebSearchEventKind string
+
+// Known event kinds. Events of other kinds report their "type" or "object"
+// value as their kind, or WebSearchEventUnknown if they have neither.
+const (
+	WebSearchEventSearchCall WebSearchEventKind = "web_search_call"
+	WebSearchEventChunk      WebSearchEventKind = "chat.completion.chunk"
+	WebSearchEventUnknown    WebSearchEventKind = "unknown"
+)
+
+// IsKnown reports whether events of this kind are modeled by WebSearchStreamEvent.
+func (k WebSearchEventKind) IsKnown() bool {
+	return k == WebSearchEventSearchCall || k == WebSearchEventChunk
+}
+
+// WebSearchEventDecoder decodes the raw JSON of an event into a custom value.
+type WebSearchEventDecoder func(raw json.RawMessage) (any, error)
+
+// Kind detects the kind of the event from its "type" and "object" fields.
+// Chunks that omit "object" are recognized by their choices or usage.
+func (e *WebSearchStreamEvent) Kind() WebSearchEventKind {
+	switch {
+	case e.Type != "":
+		return WebSearchEventKind(e.Type)
+	case e.Object != "":
+		return WebSearchEventKind(e.Object)
+	case e.Choices != nil || e.Usage != nil:
+		return WebSearchEventChunk
+	default:
+		return WebSearchEventUnknown
+	}
 }
 
 // WebSearchChoice represents a choice in the chat completion chunk with web search metadata.
@@ -121,63 +227,154 @@ func (e *WebSearchStreamEvent) ToWebSearchCall() *WebSearchCall {
 
 // WebSearchStream wraps a streaming response and parses web search events.
 type WebSearchStream struct {
-	reader    io.ReadCloser
-	scanner   *bufio.Scanner
-	current   *WebSearchStreamEvent
-	err       error
-	closed    bool
+	events  *eventStream
+	current *WebSearchStreamEvent
+	tracker *WebSearchTracker
+
+	decoders map[WebSearchEventKind]WebSearchEventDecoder
+
+	searches      searchSummary
+	blockedPolicy BlockedSearchPolicy
+}
+
+// ErrStreamIdle is the cause of a StreamAbortedError when no event arrived
+// within the stream's idle timeout.
+var ErrStreamIdle = errors.New("no event received within idle timeout")
+
+// StreamAbortedError is reported by the Err method of WebSearchStream and
+// ResponseStream when the stream was stopped because its context was done or
+// its idle timeout elapsed. Cause is the context's error or ErrStreamIdle.
+type StreamAbortedError struct {
+	Cause error
+}
+
+func (e *StreamAbortedError) Error() string {
+	return fmt.Sprintf("web search stream aborted: %v", e.Cause)
+}
+
+func (e *StreamAbortedError) Unwrap() error {
+	return e.Cause
+}
+
+// WebSearchStreamOptions configures a WebSearchStream.
+type WebSearchStreamOptions struct {
+	// MaxEventSize limits the size in bytes of a single streamed event.
+	// Larger events fail the stream with ErrEventTooLarge. Zero selects
+	// DefaultMaxEventSize.
+	MaxEventSize int
+
+	// IdleTimeout aborts the stream when Next waits longer than this for an
+	// event. Zero disables the timeout.
+	IdleTimeout time.Duration
+
+	// Tracker, when set, validates the status transitions of every web
+	// search call read from the stream. A strict tracker fails the stream
+	// with a *WebSearchProtocolError.
+	Tracker *WebSearchTracker
+
+	// BlockedSearchPolicy selects whether a blocked search is only recorded
+	// in the stream's Summary, logged, or fails the stream with a
+	// *BlockedSearchError.
+	BlockedSearchPolicy BlockedSearchPolicy
 }
 
 // NewWebSearchStream creates a new WebSearchStream from a streaming HTTP response body.
 func NewWebSearchStream(body io.ReadCloser) *WebSearchStream {
+	return NewWebSearchStreamWithOptions(body, WebSearchStreamOptions{})
+}
+
+// NewWebSearchStreamWithOptions creates a new WebSearchStream from a streaming
+// HTTP response body with the given options.
+func NewWebSearchStreamWithOptions(body io.ReadCloser, opts WebSearchStreamOptions) *WebSearchStream {
+	return NewWebSearchStreamWithContext(context.Background(), body, opts)
+}
+
+// NewWebSearchStreamWithContext creates a new WebSearchStream that is aborted
+// when ctx is done or opts.IdleTimeout elapses without an event. Aborting
+// closes body so a blocked Next returns promptly, and Err then reports a
+// *StreamAbortedError.
+func NewWebSearchStreamWithContext(ctx context.Context, body io.ReadCloser, opts WebSearchStreamOptions) *WebSearchStream {
 	return &WebSearchStream{
-		reader:  body,
-		scanner: bufio.NewScanner(body),
+		events:  newEventStream(ctx, body, opts.MaxEventSize, opts.IdleTimeout),
+		tracker: opts.Tracker,
+
+		blockedPolicy: opts.BlockedSearchPolicy,
 	}
 }
 
 // Next advances to the next event in the stream.
 // Returns true if there is a next event, false if the stream is exhausted or an error occurred.
 func (s *WebSearchStream) Next() bool {
-	if s.closed || s.err != nil {
+	if s.events.done() {
 		return false
 	}
 
-	for s.scanner.Scan() {
-		line := s.scanner.Text()
+	data, err := s.events.next()
+	if err == io.EOF || data == "[DONE]" {
+		// A clean end of stream, with or without [DONE]
+		s.events.finish(s.finishTracker())
+		return false
+	}
+	if err != nil {
+		s.events.finish(err)
+		return false
+	}
 
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, ":") {
-			continue
+	// Parse the JSON event
+	var event WebSearchStreamEvent
+	raw := []byte(data)
+	if err := json.Unmarshal(raw, &event); err != nil {
+		s.events.finish(fmt.Errorf("failed to parse stream event: %w", err))
+		return false
+	}
+	event.Raw = raw
+
+	if decode := s.decoders[event.Kind()]; decode != nil {
+		decoded, err := decode(event.Raw)
+		if err != nil {
+			s.events.finish(fmt.Errorf("failed to decode %s event: %w", event.Kind(), err))
+			return false
 		}
+		event.Decoded = decoded
+	}
 
-		// Parse SSE data lines
-		if data, ok := strings.CutPrefix(line, "data: "); ok {
+	if call := event.ToWebSearchCall(); call != nil && s.tracker != nil {
+		if err := s.tracker.Observe(call); err != nil && s.tracker.Strict {
+			s.events.finish(err)
+			return false
+		}
+	}
 
-			// Check for stream end
-			if data == "[DONE]" {
-				s.closed = true
-				return false
-			}
+	if err := s.blockedPolicy.apply(s.searches.observe(&event)); err != nil {
+		s.events.finish(err)
+		return false
+	}
 
-			// Parse the JSON event
-			var event WebSearchStreamEvent
-			if err := json.Unmarshal([]byte(data), &event); err != nil {
-				s.err = fmt.Errorf("failed to parse stream event: %w", err)
-				return false
-			}
+	s.current = &event
+	return true
+}
 
-			s.current = &event
-			return true
-		}
+// finishTracker reports searches still in progress at a clean end of the
+// stream, returning the violation only in strict mode.
+func (s *WebSearchStream) finishTracker() error {
+	if s.tracker == nil {
+		return nil
 	}
-
-	if err := s.scanner.Err(); err != nil {
-		s.err = err
+	if violation := s.tracker.Finish(); s.tracker.Strict {
+		return violation
 	}
+	return nil
+}
 
-	s.closed = true
-	return false
+// RegisterDecoder registers a decoder for events of the given kind, typically
+// one the package does not model. Its result is stored in the event's Decoded
+// field, and a decoding error fails the stream. Decoders must be registered
+// before the first call to Next.
+func (s *WebSearchStream) RegisterDecoder(kind WebSearchEventKind, decoder WebSearchEventDecoder) {
+	if s.decoders == nil {
+		s.decoders = make(map[WebSearchEventKind]WebSearchEventDecoder)
+	}
+	s.decoders[kind] = decoder
 }
 
 // Current returns the current event in the stream.
@@ -186,24 +383,93 @@ func (s *WebSearchStream) Current() *WebSearchStreamEvent {
 	return s.current
 }
 
+// Summary aggregates the web searches read from the stream so far, including
+// the details of every blocked search.
+func (s *WebSearchStream) Summary() WebSearchSummary {
+	return s.searches.summary()
+}
+
+// LastEventID returns the ID of the most recent event that set one, which can
+// be used to resume the stream.
+func (s *WebSearchStream) LastEventID() string {
+	return s.events.decoder.LastEventID()
+}
+
 // Err returns any error that occurred during streaming.
 func (s *WebSearchStream) Err() error {
-	return s.err
+	return s.events.err
 }
 
 // Close closes the underlying reader.
 func (s *WebSearchStream) Close() error {
-	s.closed = true
-	return s.reader.Close()
+	return s.events.close()
 }
 
-// ParseWebSearchMessage parses a non-streaming response body into a WebSearchMessage.
+// ParseWebSearchMessage parses a non-streaming response body into the
+// WebSearchMessage of its first choice, the first element of the choices
+// array whatever its index.
 func ParseWebSearchMessage(body []byte) (*WebSearchMessage, error) {
-	// The response is a chat completion object, we need to extract the message
+	choices, err := parseCompletionChoices(body)
+	if err != nil {
+		return nil, err
+	}
+	return &choices[0].Message, nil
+}
+
+// ParseWebSearchMessages parses a non-streaming response body into one
+// WebSearchMessage per choice, where element i holds the choice with index i.
+// Use ParseWebSearchChoices for the finish reason of each choice.
+func ParseWebSearchMessages(body []byte) ([]WebSearchMessage, error) {
+	choices, err := ParseWebSearchChoices(body)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]WebSearchMessage, len(choices))
+	for i, choice := range choices {
+		messages[i] = choice.Message
+	}
+	return messages, nil
+}
+
+// WebSearchCompletionChoice is a choice of a non-streaming response with its
+// web search metadata.
+type WebSearchCompletionChoice struct {
+	Index        int              `json:"index"`
+	Message      WebSearchMessage `json:"message"`
+	FinishReason string           `json:"finish_reason"`
+}
+
+// ParseWebSearchChoices parses a non-streaming response body into its
+// choices, where element i holds the choice with index i. Indices must
+// number the choices from 0 without gaps or repeats.
+func ParseWebSearchChoices(body []byte) ([]WebSearchCompletionChoice, error) {
+	parsed, err := parseCompletionChoices(body)
+	if err != nil {
+		return nil, err
+	}
+
+	choices := make([]WebSearchCompletionChoice, len(parsed))
+	seen := make([]bool, len(parsed))
+	for _, choice := range parsed {
+		if choice.Index < 0 || choice.Index >= len(choices) {
+			return nil, fmt.Errorf("choice index %d out of range for %d choices", choice.Index, len(choices))
+		}
+		// With every index in range, a repeated index leaves another missing
+		if seen[choice.Index] {
+			return nil, fmt.Errorf("duplicate choice index %d", choice.Index)
+		}
+		seen[choice.Index] = true
+		choices[choice.Index] = choice
+	}
+	return choices, nil
+}
+
+// parseCompletionChoices parses the choices of a non-streaming response body
+// in the order they appear.
+func parseCompletionChoices(body []byte) ([]WebSearchCompletionChoice, error) {
+	// The response is a chat completion object, we need to extract the choices
 	var response struct {
-		Choices []struct {
-			Message WebSearchMessage `json:"message"`
-		} `json:"choices"`
+		Choices []WebSearchCompletionChoice `json:"choices"`
 	}
 
 	if err := json.Unmarshal(body, &response); err != nil {
@@ -213,8 +479,7 @@ func ParseWebSearchMessage(body []byte) (*WebSearchMessage, error) {
 	if len(response.Choices) == 0 {
 		return nil, fmt.Errorf("no choices in response")
 	}
-
-	return &response.Choices[0].Message, nil
+	return response.Choices, nil
 }
 
 // ParseWebSearchResponse is a convenience function that reads and parses a response body.
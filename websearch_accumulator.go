@@ -0,0 +1,279 @@
+package tinfoil
+
+// WebSearchAccumulator assembles the events of a WebSearchStream into a
+// complete WebSearchMessage, analogous to openai.ChatCompletionAccumulator.
+type WebSearchAccumulator struct {
+	// The up-to-date accumulation of the first choice's message. Blocked
+	// searches apply to the whole request and are only recorded here.
+	WebSearchMessage
+
+	// Searches lists every web search call in the order it was first seen,
+	// each with its most recent status.
+	Searches []WebSearchCall
+
+	// FinishReason is the reason the model stopped generating the first
+	// choice, once known.
+	FinishReason string
+
+	// ID, Model and Created are taken from the chat completion chunks.
+	ID      string
+	Model   string
+	Created int64
+
+	// Usage is the token usage of the request, when the stream reports it.
+	Usage *Usage
+
+	// Choices holds the accumulation of every choice, where element i is the
+	// choice with index i. Use it for requests with more than one choice.
+	// Choices with an index of MaxChoices or more are not accumulated.
+	Choices []AccumulatedChoice
+
+	searchIndex map[string]int
+	inContent   bool
+
+	justFinishedContent  bool
+	justFinishedSearchID string
+}
+
+// MaxChoices bounds the choice indices a WebSearchAccumulator accumulates,
+// matching the largest number of choices the API lets a request ask for.
+const MaxChoices = 128
+
+// MaxToolCalls bounds the tool call indices a WebSearchAccumulator
+// accumulates per choice. Fragments with a larger index are dropped.
+const MaxToolCalls = 128
+
+// AccumulatedChoice is the up-to-date accumulation of a single streamed choice.
+type AccumulatedChoice struct {
+	Index int
+	WebSearchMessage
+	FinishReason string
+}
+
+// AddEvent incorporates a stream event into the accumulation. Events must be
+// added in order. Returns false if the event could not be accumulated,
+// including events of unknown kinds.
+func (acc *WebSearchAccumulator) AddEvent(event *WebSearchStreamEvent) bool {
+	acc.clearJustFinished()
+
+	if event == nil {
+		return false
+	}
+
+	if call := event.ToWebSearchCall(); call != nil {
+		if call.ID == "" {
+			return false
+		}
+		acc.finishContent()
+		acc.addSearchCall(call)
+		return true
+	}
+	if event.Kind() != WebSearchEventChunk {
+		return false
+	}
+
+	if event.ID != "" {
+		acc.ID = event.ID
+	}
+	if event.Model != "" {
+		acc.Model = event.Model
+	}
+	if event.Created != 0 {
+		acc.Created = event.Created
+	}
+	if event.Usage != nil {
+		acc.Usage = event.Usage
+	}
+
+	var sawFirst, sawContent bool
+	for _, choice := range event.Choices {
+		if choice.Index < 0 || choice.Index >= MaxChoices {
+			continue
+		}
+		c := acc.choice(choice.Index)
+
+		if delta := choice.Delta; delta != nil {
+			if delta.Role != "" {
+				c.Role = delta.Role
+			}
+			c.Content += delta.Content
+			c.Refusal += delta.Refusal
+			for _, call := range delta.ToolCalls {
+				c.ToolCalls = addToolCall(c.ToolCalls, call)
+			}
+			c.Annotations = append(c.Annotations, delta.Annotations...)
+			c.SearchReasoning += delta.SearchReasoning
+			for _, item := range delta.ReasoningItems {
+				c.ReasoningItems = addReasoningItem(c.ReasoningItems, item)
+			}
+			// Blocked searches apply to the whole request
+			for _, blocked := range delta.BlockedSearches {
+				if !acc.searchEndedUnblocked(blocked.ID) {
+					acc.BlockedSearches, _ = mergeBlockedSearch(acc.BlockedSearches, blocked)
+				}
+			}
+		}
+		if choice.FinishReason != "" {
+			c.FinishReason = choice.FinishReason
+		}
+
+		// Content completion is tracked for the first choice only
+		if choice.Index != 0 {
+			continue
+		}
+		acc.syncFirstChoice()
+		sawFirst = true
+		if choice.Delta != nil && choice.Delta.Content != "" {
+			acc.inContent = true
+			sawContent = true
+		}
+		if choice.FinishReason != "" {
+			acc.finishContent()
+		}
+	}
+
+	// Chunks of other choices say nothing about the first one's content
+	if !sawContent && (sawFirst || len(event.Choices) == 0) {
+		acc.finishContent()
+	}
+	return true
+}
+
+// choice returns the accumulation of the choice with the given index,
+// growing Choices as needed. The index must be below MaxChoices.
+func (acc *WebSearchAccumulator) choice(index int) *AccumulatedChoice {
+	for len(acc.Choices) <= index {
+		acc.Choices = append(acc.Choices, AccumulatedChoice{Index: len(acc.Choices)})
+	}
+	return &acc.Choices[index]
+}
+
+// syncFirstChoice mirrors the first choice into the embedded message.
+func (acc *WebSearchAccumulator) syncFirstChoice() {
+	first := &acc.Choices[0]
+	acc.Role = first.Role
+	acc.Content = first.Content
+	acc.Refusal = first.Refusal
+	acc.ToolCalls = first.ToolCalls
+	acc.Annotations = first.Annotations
+	acc.SearchReasoning = first.SearchReasoning
+	acc.ReasoningItems = first.ReasoningItems
+	acc.FinishReason = first.FinishReason
+}
+
+// JustFinishedContent retrieves the accumulated content of the first choice
+// when it is known to have just been completed, which is when the last added
+// event carries the first choice without a content delta, finishes it, or is
+// a search call or a chunk without choices.
+func (acc *WebSearchAccumulator) JustFinishedContent() (content string, ok bool) {
+	if acc.justFinishedContent {
+		return acc.Content, true
+	}
+	return "", false
+}
+
+// JustFinishedSearch retrieves a web search call when the last added event
+// moved it to a terminal status: "completed", "failed" or "blocked".
+func (acc *WebSearchAccumulator) JustFinishedSearch() (search WebSearchCall, ok bool) {
+	if acc.justFinishedSearchID != "" {
+		return acc.Searches[acc.searchIndex[acc.justFinishedSearchID]], true
+	}
+	return WebSearchCall{}, false
+}
+
+// clearJustFinished forgets what the previous event finished.
+func (acc *WebSearchAccumulator) clearJustFinished() {
+	acc.justFinishedContent = false
+	acc.justFinishedSearchID = ""
+}
+
+// finishContent marks in-progress content as just finished.
+func (acc *WebSearchAccumulator) finishContent() {
+	if acc.inContent {
+		acc.inContent = false
+		acc.justFinishedContent = true
+	}
+}
+
+// addSearchCall records a status update for a web search call.
+func (acc *WebSearchAccumulator) addSearchCall(call *WebSearchCall) {
+	if acc.searchIndex == nil {
+		acc.searchIndex = make(map[string]int)
+	}
+
+	i, seen := acc.searchIndex[call.ID]
+	if !seen {
+		i = len(acc.Searches)
+		acc.searchIndex[call.ID] = i
+		acc.Searches = append(acc.Searches, WebSearchCall{Type: call.Type, ID: call.ID})
+	}
+
+	search := &acc.Searches[i]
+	// Keep the first terminal status, as WebSearchTracker does, so a later
+	// event cannot finish the search again or overwrite how it ended. Later
+	// events may still fill in details, such as the Responses API's action.
+	wasTerminal := search.Status.IsTerminal()
+	if !wasTerminal {
+		search.Status = call.Status
+	}
+	if call.Reason != "" && call.Status == search.Status {
+		search.Reason = call.Reason
+	}
+	if call.Action != nil {
+		search.Action = call.Action
+	}
+
+	if wasTerminal || !search.Status.IsTerminal() {
+		return
+	}
+	acc.justFinishedSearchID = search.ID
+
+	if blocked, ok := search.BlockedSearch(); ok {
+		acc.BlockedSearches, _ = mergeBlockedSearch(acc.BlockedSearches, blocked)
+	}
+}
+
+// searchEndedUnblocked reports whether the search call with id already
+// completed or failed, so a delta reporting it blocked is ignored.
+func (acc *WebSearchAccumulator) searchEndedUnblocked(id string) bool {
+	i, ok := acc.searchIndex[id]
+	return ok && id != "" && endedUnblocked(acc.Searches[i].Status)
+}
+
+// addToolCall merges a streamed tool call fragment into calls at its index,
+// concatenating argument fragments.
+func addToolCall(calls []ToolCall, delta ToolCall) []ToolCall {
+	if delta.Index < 0 || delta.Index >= MaxToolCalls {
+		return calls
+	}
+	for len(calls) <= delta.Index {
+		calls = append(calls, ToolCall{Index: len(calls)})
+	}
+
+	call := &calls[delta.Index]
+	if delta.ID != "" {
+		call.ID = delta.ID
+	}
+	if delta.Type != "" {
+		call.Type = delta.Type
+	}
+	if delta.Function.Name != "" {
+		call.Function.Name = delta.Function.Name
+	}
+	call.Function.Arguments += delta.Function.Arguments
+	return calls
+}
+
+// addReasoningItem appends a reasoning item to items, merging summary parts
+// into an earlier item with the same ID.
+func addReasoningItem(items []ReasoningItem, item ReasoningItem) []ReasoningItem {
+	if item.ID != "" {
+		for i := range items {
+			if items[i].ID == item.ID {
+				items[i].Summary = append(items[i].Summary, item.Summary...)
+				return items
+			}
+		}
+	}
+	return append(items, item)
+}
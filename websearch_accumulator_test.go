@@ -0,0 +1,297 @@
+package tinfoil
+
+import (
+	"testing"
+)
+
+func TestWebSearchAccumulator(t *testing.T) {
+	sseData := `data: {"type":"web_search_call","id":"ws_1","status":"in_progress","action":{"type":"search","query":"quantum news"}}
+
+data: {"type":"web_search_call","id":"ws_2","status":"in_progress","action":{"type":"search","query":"john smith ssn"}}
+
+data: {"type":"web_search_call","id":"ws_1","status":"completed","action":{"type":"search","query":"quantum news"}}
+
+data: {"type":"web_search_call","id":"ws_2","status":"blocked","reason":"SSN detected"}
+
+data: {"choices":[{"index":0,"delta":{"search_reasoning":"Looking up ","reasoning_items":[{"id":"r_1","type":"reasoning","summary":[{"type":"summary_text","text":"Step one"}]}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"search_reasoning":"recent news.","reasoning_items":[{"id":"r_1","type":"reasoning","summary":[{"type":"summary_text","text":"Step two"}]}],"annotations":[{"type":"url_citation","url_citation":{"title":"Quantum News","url":"https://example.com/quantum"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"Here are "}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"the results."}}]}
+
+data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+
+	stream := SimulateWebSearchStream(sseData)
+	defer stream.Close()
+
+	acc := WebSearchAccumulator{}
+	var finishedSearches []WebSearchCall
+	var finishedContent []string
+	for stream.Next() {
+		if !acc.AddEvent(stream.Current()) {
+			t.Fatal("Failed to accumulate event")
+		}
+		if search, ok := acc.JustFinishedSearch(); ok {
+			finishedSearches = append(finishedSearches, search)
+		}
+		if content, ok := acc.JustFinishedContent(); ok {
+			finishedContent = append(finishedContent, content)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+
+	if acc.Content != "Here are the results." {
+		t.Errorf("Content mismatch: %q", acc.Content)
+	}
+	if len(finishedContent) != 1 || finishedContent[0] != acc.Content {
+		t.Errorf("Expected content to finish once, got %q", finishedContent)
+	}
+	if acc.FinishReason != "stop" {
+		t.Errorf("Expected finish reason 'stop', got '%s'", acc.FinishReason)
+	}
+	if acc.SearchReasoning != "Looking up recent news." {
+		t.Errorf("Search reasoning mismatch: %q", acc.SearchReasoning)
+	}
+	if len(acc.ReasoningItems) != 1 || len(acc.ReasoningItems[0].Summary) != 2 {
+		t.Fatalf("Expected one reasoning item with two summary parts, got %+v", acc.ReasoningItems)
+	}
+	if len(acc.Annotations) != 1 || acc.Annotations[0].URLCitation.Title != "Quantum News" {
+		t.Errorf("Annotations mismatch: %+v", acc.Annotations)
+	}
+
+	if len(acc.Searches) != 2 {
+		t.Fatalf("Expected 2 searches, got %d", len(acc.Searches))
+	}
+	if acc.Searches[0].ID != "ws_1" || acc.Searches[0].Status != "completed" {
+		t.Errorf("First search mismatch: %+v", acc.Searches[0])
+	}
+	if acc.Searches[1].Status != "blocked" || acc.Searches[1].Reason != "SSN detected" {
+		t.Errorf("Second search mismatch: %+v", acc.Searches[1])
+	}
+	// The query is kept from the in_progress event when the update omits it
+	if acc.Searches[1].Action == nil || acc.Searches[1].Action.Query != "john smith ssn" {
+		t.Errorf("Blocked search lost its query: %+v", acc.Searches[1].Action)
+	}
+
+	if len(finishedSearches) != 2 || finishedSearches[0].ID != "ws_1" || finishedSearches[1].ID != "ws_2" {
+		t.Errorf("Expected both searches to finish in order, got %+v", finishedSearches)
+	}
+
+	if len(acc.BlockedSearches) != 1 || acc.BlockedSearches[0].Query != "john smith ssn" {
+		t.Errorf("Blocked searches mismatch: %+v", acc.BlockedSearches)
+	}
+}
+
+func TestWebSearchAccumulatorRejectsInvalidEvents(t *testing.T) {
+	acc := WebSearchAccumulator{}
+
+	if acc.AddEvent(nil) {
+		t.Error("Nil event should not be accumulated")
+	}
+	if acc.AddEvent(&WebSearchStreamEvent{Type: "web_search_call", Status: "in_progress"}) {
+		t.Error("Web search call without ID should not be accumulated")
+	}
+	if _, ok := acc.JustFinishedSearch(); ok {
+		t.Error("No search should have finished")
+	}
+
+	// A choice index from the server must not grow Choices without bound
+	acc.AddEvent(&WebSearchStreamEvent{Choices: []WebSearchChoice{{Index: 1 << 30, Delta: &WebSearchDelta{Content: "x"}}}})
+	if len(acc.Choices) != 0 {
+		t.Errorf("Expected choices beyond MaxChoices to be ignored, got %d choices", len(acc.Choices))
+	}
+}
+
+func TestWebSearchAccumulatorMultipleChoices(t *testing.T) {
+	sseData := `data: {"choices":[{"index":0,"delta":{"content":"First "}},{"index":1,"delta":{"content":"Second "}}]}
+
+data: {"choices":[{"index":1,"delta":{"content":"answer.","annotations":[{"type":"url_citation","url_citation":{"title":"B","url":"https://b.example"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"answer."}}]}
+
+data: {"choices":[{"index":1,"delta":{},"finish_reason":"length"}]}
+
+data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+
+	stream := SimulateWebSearchStream(sseData)
+	defer stream.Close()
+
+	acc := WebSearchAccumulator{}
+	for stream.Next() {
+		if !acc.AddEvent(stream.Current()) {
+			t.Fatal("Failed to accumulate event")
+		}
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+
+	if len(acc.Choices) != 2 {
+		t.Fatalf("Expected 2 choices, got %d", len(acc.Choices))
+	}
+	first, second := acc.Choices[0], acc.Choices[1]
+	if first.Index != 0 || first.Content != "First answer." || first.FinishReason != "stop" || len(first.Annotations) != 0 {
+		t.Errorf("First choice mismatch: %+v", first)
+	}
+	if second.Index != 1 || second.Content != "Second answer." || second.FinishReason != "length" || len(second.Annotations) != 1 {
+		t.Errorf("Second choice mismatch: %+v", second)
+	}
+
+	// The embedded message mirrors the first choice
+	if acc.Content != "First answer." || acc.FinishReason != "stop" {
+		t.Errorf("Embedded message mismatch: %q, %q", acc.Content, acc.FinishReason)
+	}
+}
+
+func TestWebSearchAccumulatorInterleavedChoices(t *testing.T) {
+	sseData := `data: {"choices":[{"index":0,"delta":{"content":"A"}}]}
+
+data: {"choices":[{"index":1,"delta":{"content":"X"}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"B"}}]}
+
+data: {"choices":[{"index":1,"delta":{"content":"Y"}}]}
+
+data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}
+
+data: {"choices":[{"index":1,"delta":{},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+
+	stream := SimulateWebSearchStream(sseData)
+	defer stream.Close()
+
+	acc := WebSearchAccumulator{}
+	var finished []string
+	for stream.Next() {
+		acc.AddEvent(stream.Current())
+		if content, ok := acc.JustFinishedContent(); ok {
+			finished = append(finished, content)
+		}
+	}
+
+	// Chunks of the second choice do not finish the first one's content
+	if len(finished) != 1 || finished[0] != "AB" {
+		t.Errorf("Expected the first choice to finish once with \"AB\", got %q", finished)
+	}
+}
+
+func TestWebSearchAccumulatorToolCallsAndUsage(t *testing.T) {
+	sseData := `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1760000000,"model":"gpt-oss-120b","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1760000000,"model":"gpt-oss-120b","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1760000000,"model":"gpt-oss-120b","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}},{"index":1,"id":"call_2","type":"function","function":{"name":"get_time","arguments":"{}"}}]}}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1760000000,"model":"gpt-oss-120b","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1760000000,"model":"gpt-oss-120b","choices":[],"usage":{"prompt_tokens":20,"completion_tokens":12,"total_tokens":32,"completion_tokens_details":{"reasoning_tokens":4}}}
+
+data: [DONE]
+
+`
+
+	stream := SimulateWebSearchStream(sseData)
+	defer stream.Close()
+
+	acc := WebSearchAccumulator{}
+	for stream.Next() {
+		if !acc.AddEvent(stream.Current()) {
+			t.Fatal("Failed to accumulate event")
+		}
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+
+	if acc.ID != "chatcmpl-1" || acc.Model != "gpt-oss-120b" || acc.Created != 1760000000 {
+		t.Errorf("Chunk metadata mismatch: %q %q %d", acc.ID, acc.Model, acc.Created)
+	}
+	if acc.Role != "assistant" || acc.FinishReason != "tool_calls" {
+		t.Errorf("Expected assistant role and tool_calls finish reason, got %q %q", acc.Role, acc.FinishReason)
+	}
+	if len(acc.ToolCalls) != 2 {
+		t.Fatalf("Expected 2 tool calls, got %+v", acc.ToolCalls)
+	}
+	if call := acc.ToolCalls[0]; call.ID != "call_1" || call.Function.Name != "get_weather" || call.Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("First tool call mismatch: %+v", call)
+	}
+	if call := acc.ToolCalls[1]; call.ID != "call_2" || call.Function.Name != "get_time" || call.Function.Arguments != "{}" {
+		t.Errorf("Second tool call mismatch: %+v", call)
+	}
+
+	if acc.Usage == nil || acc.Usage.TotalTokens != 32 || acc.Usage.CompletionTokensDetails == nil || acc.Usage.CompletionTokensDetails.ReasoningTokens != 4 {
+		t.Errorf("Usage mismatch: %+v", acc.Usage)
+	}
+
+	// A tool call index from the server must not grow ToolCalls without bound
+	acc.AddEvent(&WebSearchStreamEvent{Choices: []WebSearchChoice{{Delta: &WebSearchDelta{ToolCalls: []ToolCall{{Index: 1 << 30}}}}}})
+	if len(acc.ToolCalls) != 2 {
+		t.Errorf("Expected tool calls beyond MaxToolCalls to be ignored, got %d tool calls", len(acc.ToolCalls))
+	}
+}
+
+func TestWebSearchAccumulatorRefusal(t *testing.T) {
+	acc := WebSearchAccumulator{}
+	acc.AddEvent(&WebSearchStreamEvent{Choices: []WebSearchChoice{{Delta: &WebSearchDelta{Role: "assistant", Refusal: "I can't "}}}})
+	acc.AddEvent(&WebSearchStreamEvent{Choices: []WebSearchChoice{{Delta: &WebSearchDelta{Refusal: "help with that."}}}})
+
+	if acc.Refusal != "I can't help with that." {
+		t.Errorf("Refusal mismatch: %q", acc.Refusal)
+	}
+}
+
+func TestWebSearchAccumulatorKeepsFirstTerminalStatus(t *testing.T) {
+	acc := WebSearchAccumulator{}
+	var finished int
+	for _, status := range []WebSearchStatus{"in_progress", "completed", "in_progress", "completed", "blocked"} {
+		acc.AddEvent(&WebSearchStreamEvent{Type: "web_search_call", ID: "ws_1", Status: status, Reason: "PII"})
+		if _, ok := acc.JustFinishedSearch(); ok {
+			finished++
+		}
+	}
+
+	if finished != 1 {
+		t.Errorf("Expected the search to finish once, got %d", finished)
+	}
+	if len(acc.Searches) != 1 || acc.Searches[0].Status != "completed" {
+		t.Errorf("Expected the search to stay completed, got %+v", acc.Searches)
+	}
+	if len(acc.BlockedSearches) != 0 {
+		t.Errorf("Expected no blocked searches, got %+v", acc.BlockedSearches)
+	}
+}
+
+func TestWebSearchAccumulatorBlockedAfterCompleted(t *testing.T) {
+	stream := SimulateWebSearchStream(blockedAfterCompletedStream)
+	defer stream.Close()
+
+	acc := WebSearchAccumulator{}
+	for stream.Next() {
+		acc.AddEvent(stream.Current())
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+
+	if len(acc.Searches) != 1 || acc.Searches[0].Status != "completed" {
+		t.Errorf("Expected the search to stay completed, got %+v", acc.Searches)
+	}
+	if len(acc.BlockedSearches) != 0 {
+		t.Errorf("Expected no blocked searches, got %+v", acc.BlockedSearches)
+	}
+}
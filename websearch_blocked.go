@@ -0,0 +1,214 @@
+package tinfoil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go/v3/option"
+	log "github.com/sirupsen/logrus"
+)
+
+// BlockedSearchPolicy selects how a web search request reacts to searches
+// the server blocked because their query contained personally identifiable
+// information.
+type BlockedSearchPolicy int
+
+const (
+	// BlockedSearchDefault inherits the policy of the client sending the
+	// request. Where there is none, it behaves as BlockedSearchAllow.
+	BlockedSearchDefault BlockedSearchPolicy = iota
+	// BlockedSearchAllow only reports blocked searches, e.g. in
+	// WebSearchStream.Summary or WebSearchMessage.BlockedSearches.
+	BlockedSearchAllow
+	// BlockedSearchWarn additionally logs a warning for each blocked search.
+	BlockedSearchWarn
+	// BlockedSearchFail fails the request with a *BlockedSearchError.
+	BlockedSearchFail
+)
+
+// WithBlockedSearchPolicy returns an option that sets
+// ClientOptions.BlockedSearchPolicy when passed to a Client constructor, such
+// as NewClient.
+func WithBlockedSearchPolicy(policy BlockedSearchPolicy) option.RequestOption {
+	return newClientOption(func(opts *ClientOptions) { opts.BlockedSearchPolicy = policy })
+}
+
+// BlockedSearchError is returned under BlockedSearchFail when a search was
+// blocked. Its message omits the queries, which contain the blocked PII.
+type BlockedSearchError struct {
+	Searches []BlockedSearch
+}
+
+func (e *BlockedSearchError) Error() string {
+	reasons := make([]string, 0, len(e.Searches))
+	for _, search := range e.Searches {
+		reason := search.Reason
+		if reason == "" {
+			reason = "no reason given"
+		}
+		reasons = append(reasons, fmt.Sprintf("%s (%s)", search.ID, reason))
+	}
+	return fmt.Sprintf("web search blocked for personal information: %s", strings.Join(reasons, ", "))
+}
+
+// apply enforces the policy for newly blocked searches.
+func (p BlockedSearchPolicy) apply(blocked []BlockedSearch) error {
+	if len(blocked) == 0 {
+		return nil
+	}
+
+	switch p {
+	case BlockedSearchWarn:
+		// Never log the query, it is what contains the personal information
+		for _, search := range blocked {
+			log.Warnf("Web search %s was blocked: %s", search.ID, search.Reason)
+		}
+	case BlockedSearchFail:
+		return &BlockedSearchError{Searches: blocked}
+	}
+	return nil
+}
+
+// BlockedSearch returns the details of the call as a BlockedSearch if its
+// status is blocked.
+func (c *WebSearchCall) BlockedSearch() (BlockedSearch, bool) {
+	if !c.Status.IsBlocked() {
+		return BlockedSearch{}, false
+	}
+	blocked := BlockedSearch{ID: c.ID, Reason: c.Reason}
+	if c.Action != nil {
+		blocked.Query = c.Action.Query
+	}
+	return blocked, true
+}
+
+// WebSearchSummary aggregates the web searches of a stream.
+type WebSearchSummary struct {
+	Searches  int // Distinct searches seen
+	Completed int
+	Failed    int
+	Blocked   int
+	Pending   int // Searches without a terminal status
+
+	// BlockedSearches details every blocked search in the order it was blocked.
+	BlockedSearches []BlockedSearch
+}
+
+// searchSummary builds a WebSearchSummary from stream events.
+type searchSummary struct {
+	order    []string
+	statuses map[string]WebSearchStatus
+	queries  map[string]string
+	blocked  []BlockedSearch
+}
+
+// observe records an event and returns the searches it newly blocked.
+func (s *searchSummary) observe(event *WebSearchStreamEvent) []BlockedSearch {
+	if s.statuses == nil {
+		s.statuses = make(map[string]WebSearchStatus)
+		s.queries = make(map[string]string)
+	}
+
+	var added []BlockedSearch
+	if call := event.ToWebSearchCall(); call != nil {
+		if call.ID == "" {
+			return nil
+		}
+		if _, seen := s.statuses[call.ID]; !seen {
+			s.order = append(s.order, call.ID)
+		}
+		if call.Action != nil && call.Action.Query != "" {
+			s.queries[call.ID] = call.Action.Query
+		}
+		// A terminal status is final, so a search that already ended is not
+		// blocked by a later event
+		if s.statuses[call.ID].IsTerminal() {
+			return nil
+		}
+		s.statuses[call.ID] = call.Status
+
+		if blocked, ok := call.BlockedSearch(); ok {
+			blocked.Query = s.queries[call.ID]
+			if s.addBlocked(blocked) {
+				added = append(added, blocked)
+			}
+		}
+		return added
+	}
+
+	for _, choice := range event.Choices {
+		if choice.Delta == nil {
+			continue
+		}
+		for _, blocked := range choice.Delta.BlockedSearches {
+			if endedUnblocked(s.statuses[blocked.ID]) {
+				continue
+			}
+			if s.addBlocked(blocked) {
+				added = append(added, blocked)
+			}
+		}
+	}
+	return added
+}
+
+// endedUnblocked reports whether a search with status already completed or
+// failed, so a later report of it being blocked is ignored.
+func endedUnblocked(status WebSearchStatus) bool {
+	return status.IsTerminal() && !status.IsBlocked()
+}
+
+// addBlocked merges a blocked search into the summary, reporting whether it
+// was not known to be blocked before.
+func (s *searchSummary) addBlocked(blocked BlockedSearch) bool {
+	var added bool
+	s.blocked, added = mergeBlockedSearch(s.blocked, blocked)
+	return added
+}
+
+// summary returns the current aggregate.
+func (s *searchSummary) summary() WebSearchSummary {
+	summary := WebSearchSummary{
+		Searches:        len(s.order),
+		BlockedSearches: append([]BlockedSearch(nil), s.blocked...),
+	}
+	for _, id := range s.order {
+		switch s.statuses[id] {
+		case WebSearchStatusCompleted:
+			summary.Completed++
+		case WebSearchStatusFailed:
+			summary.Failed++
+		case WebSearchStatusBlocked:
+			summary.Blocked++
+		default:
+			summary.Pending++
+		}
+	}
+	// Blocked searches reported only in deltas have no search call event
+	for _, blocked := range s.blocked {
+		if _, seen := s.statuses[blocked.ID]; !seen {
+			summary.Searches++
+			summary.Blocked++
+		}
+	}
+	return summary
+}
+
+// mergeBlockedSearch adds blocked to list, filling in the details of an
+// entry with the same ID if there is one. It reports whether blocked was new.
+func mergeBlockedSearch(list []BlockedSearch, blocked BlockedSearch) ([]BlockedSearch, bool) {
+	if blocked.ID != "" {
+		for i := range list {
+			if list[i].ID == blocked.ID {
+				if list[i].Query == "" {
+					list[i].Query = blocked.Query
+				}
+				if list[i].Reason == "" {
+					list[i].Reason = blocked.Reason
+				}
+				return list, false
+			}
+		}
+	}
+	return append(list, blocked), true
+}
@@ -0,0 +1,157 @@
+package tinfoil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const blockedTestStream = `data: {"type":"web_search_call","id":"ws_1","status":"in_progress","action":{"type":"search","query":"go news"}}
+
+data: {"type":"web_search_call","id":"ws_2","status":"in_progress","action":{"type":"search","query":"john smith ssn 123-45-6789"}}
+
+data: {"type":"web_search_call","id":"ws_1","status":"completed","action":{"type":"search","query":"go news"}}
+
+data: {"type":"web_search_call","id":"ws_2","status":"blocked","reason":"SSN detected"}
+
+data: {"type":"web_search_call","id":"ws_3","status":"in_progress","action":{"type":"search","query":"go blog"}}
+
+data: {"choices":[{"index":0,"delta":{"blocked_searches":[{"id":"ws_2","query":"john smith ssn 123-45-6789","reason":"SSN detected"},{"id":"ws_4","query":"card 4111 1111 1111 1111","reason":"Card number detected"}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"Done."},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+
+func TestStreamSummary(t *testing.T) {
+	stream := SimulateWebSearchStream(blockedTestStream)
+	defer stream.Close()
+
+	var acc WebSearchAccumulator
+	for stream.Next() {
+		acc.AddEvent(stream.Current())
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+
+	summary := stream.Summary()
+	if summary.Searches != 4 || summary.Completed != 1 || summary.Blocked != 2 || summary.Failed != 0 || summary.Pending != 1 {
+		t.Errorf("Unexpected summary counts: %+v", summary)
+	}
+	want := []BlockedSearch{
+		{ID: "ws_2", Query: "john smith ssn 123-45-6789", Reason: "SSN detected"},
+		{ID: "ws_4", Query: "card 4111 1111 1111 1111", Reason: "Card number detected"},
+	}
+	if len(summary.BlockedSearches) != len(want) {
+		t.Fatalf("Expected %d blocked searches, got %+v", len(want), summary.BlockedSearches)
+	}
+	for i := range want {
+		if summary.BlockedSearches[i] != want[i] {
+			t.Errorf("Blocked search %d: expected %+v, got %+v", i, want[i], summary.BlockedSearches[i])
+		}
+	}
+
+	// The accumulator merges streamed details without duplicating searches
+	if len(acc.BlockedSearches) != 2 || acc.BlockedSearches[0].Query != want[0].Query {
+		t.Errorf("Accumulated blocked searches mismatch: %+v", acc.BlockedSearches)
+	}
+}
+
+func TestStreamBlockedSearchFail(t *testing.T) {
+	stream := NewWebSearchStreamWithOptions(io.NopCloser(strings.NewReader(blockedTestStream)),
+		WebSearchStreamOptions{BlockedSearchPolicy: BlockedSearchFail})
+	defer stream.Close()
+
+	events := 0
+	for stream.Next() {
+		events++
+	}
+	if events != 3 {
+		t.Errorf("Expected the stream to stop at the blocked search, got %d events", events)
+	}
+
+	var blockedErr *BlockedSearchError
+	if !errors.As(stream.Err(), &blockedErr) {
+		t.Fatalf("Expected BlockedSearchError, got %v", stream.Err())
+	}
+	if len(blockedErr.Searches) != 1 || blockedErr.Searches[0].Query != "john smith ssn 123-45-6789" {
+		t.Errorf("Unexpected blocked searches: %+v", blockedErr.Searches)
+	}
+	if strings.Contains(blockedErr.Error(), "123-45-6789") {
+		t.Errorf("Error message leaks the blocked query: %s", blockedErr.Error())
+	}
+}
+
+// blockedAfterCompletedStream reports a search blocked after it completed.
+const blockedAfterCompletedStream = `data: {"type":"web_search_call","id":"ws_1","status":"in_progress","action":{"type":"search","query":"go news"}}
+
+data: {"type":"web_search_call","id":"ws_1","status":"completed"}
+
+data: {"type":"web_search_call","id":"ws_1","status":"blocked","reason":"PII"}
+
+data: {"choices":[{"index":0,"delta":{"blocked_searches":[{"id":"ws_1","query":"go news","reason":"PII"}]}}]}
+
+data: [DONE]
+
+`
+
+func TestStreamBlockedAfterCompleted(t *testing.T) {
+	stream := NewWebSearchStreamWithOptions(io.NopCloser(strings.NewReader(blockedAfterCompletedStream)),
+		WebSearchStreamOptions{BlockedSearchPolicy: BlockedSearchFail})
+	defer stream.Close()
+
+	for stream.Next() {
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("A search that already completed should not fail the stream: %v", err)
+	}
+
+	summary := stream.Summary()
+	if summary.Completed != 1 || summary.Blocked != 0 || len(summary.BlockedSearches) != 0 {
+		t.Errorf("Expected the search to stay completed, got %+v", summary)
+	}
+}
+
+func TestStreamBlockedSearchWarn(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	stream := NewWebSearchStreamWithOptions(io.NopCloser(strings.NewReader(blockedTestStream)),
+		WebSearchStreamOptions{BlockedSearchPolicy: BlockedSearchWarn})
+	defer stream.Close()
+
+	for stream.Next() {
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+
+	output := logs.String()
+	if strings.Count(output, "level=warning") != 2 || !strings.Contains(output, "ws_2") || !strings.Contains(output, "ws_4") {
+		t.Errorf("Expected one warning per blocked search, got:\n%s", output)
+	}
+	if strings.Contains(output, "123-45-6789") || strings.Contains(output, "4111") {
+		t.Errorf("Warning leaks a blocked query:\n%s", output)
+	}
+}
+
+func TestWebSearchCallBlockedSearch(t *testing.T) {
+	call := WebSearchCall{ID: "ws_1", Status: WebSearchStatusBlocked, Reason: "PII", Action: &WebSearchAction{Query: "secret"}}
+	blocked, ok := call.BlockedSearch()
+	if !ok || blocked != (BlockedSearch{ID: "ws_1", Query: "secret", Reason: "PII"}) {
+		t.Errorf("Unexpected blocked search: %+v (%v)", blocked, ok)
+	}
+
+	call.Status = WebSearchStatusCompleted
+	if _, ok := call.BlockedSearch(); ok {
+		t.Error("Expected a completed search not to be blocked")
+	}
+}
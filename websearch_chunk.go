@@ -0,0 +1,124 @@
+package tinfoil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+)
+
+// WebSearchEventFromChunk recovers the web search metadata that openai-go
+// drops from a chunk read with Chat.Completions.NewStreaming. A web search
+// call event decodes into a chunk without choices; the returned event then
+// reports IsWebSearchCall. Otherwise the event's choices carry the
+// annotations, search reasoning and blocked searches of each delta.
+func WebSearchEventFromChunk(chunk openai.ChatCompletionChunk) (*WebSearchStreamEvent, error) {
+	raw := chunk.RawJSON()
+	if raw == "" {
+		return nil, fmt.Errorf("chunk has no raw JSON")
+	}
+
+	var event WebSearchStreamEvent
+	if err := json.Unmarshal([]byte(raw), &event); err != nil {
+		return nil, fmt.Errorf("failed to parse chunk: %w", err)
+	}
+	event.Raw = json.RawMessage(raw)
+	return &event, nil
+}
+
+// WebSearchDeltaFromChunk recovers the web search metadata of a single
+// openai-go chunk delta.
+func WebSearchDeltaFromChunk(delta openai.ChatCompletionChunkChoiceDelta) (*WebSearchDelta, error) {
+	raw := delta.RawJSON()
+	if raw == "" {
+		return &WebSearchDelta{}, nil
+	}
+
+	var d WebSearchDelta
+	if err := json.Unmarshal([]byte(raw), &d); err != nil {
+		return nil, fmt.Errorf("failed to parse chunk delta: %w", err)
+	}
+	return &d, nil
+}
+
+// WebSearchChunkStream reads a streaming web search response, presenting
+// chat completion chunks as openai-go's ChatCompletionChunk alongside web
+// search call events, so both can be consumed from a single request.
+type WebSearchChunkStream struct {
+	stream  *WebSearchStream
+	chunk   openai.ChatCompletionChunk
+	isChunk bool
+	err     error
+}
+
+// NewWebSearchChunkStream wraps a WebSearchStream.
+func NewWebSearchChunkStream(stream *WebSearchStream) *WebSearchChunkStream {
+	return &WebSearchChunkStream{stream: stream}
+}
+
+// Next advances to the next event. It returns false at the end of the stream
+// or on error.
+func (s *WebSearchChunkStream) Next() bool {
+	if s.err != nil || !s.stream.Next() {
+		return false
+	}
+
+	event := s.stream.Current()
+	s.chunk = openai.ChatCompletionChunk{}
+	s.isChunk = event.Kind() == WebSearchEventChunk
+	if s.isChunk {
+		if err := json.Unmarshal(event.Raw, &s.chunk); err != nil {
+			s.err = fmt.Errorf("failed to parse chat completion chunk: %w", err)
+			return false
+		}
+	}
+	return true
+}
+
+// Current returns the current event with its web search metadata.
+func (s *WebSearchChunkStream) Current() *WebSearchStreamEvent {
+	return s.stream.Current()
+}
+
+// Chunk returns the current event as an openai-go chunk, for use with
+// openai.ChatCompletionAccumulator. ok is false for events that are not chat
+// completion chunks, such as web search calls.
+func (s *WebSearchChunkStream) Chunk() (chunk openai.ChatCompletionChunk, ok bool) {
+	return s.chunk, s.isChunk
+}
+
+// SearchCall returns the current event as a web search call, or nil if it is
+// not one.
+func (s *WebSearchChunkStream) SearchCall() *WebSearchCall {
+	return s.stream.Current().ToWebSearchCall()
+}
+
+// Summary aggregates the web searches read so far.
+func (s *WebSearchChunkStream) Summary() WebSearchSummary {
+	return s.stream.Summary()
+}
+
+// Err returns any error that occurred during streaming.
+func (s *WebSearchChunkStream) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.stream.Err()
+}
+
+// Close closes the underlying stream.
+func (s *WebSearchChunkStream) Close() error {
+	return s.stream.Close()
+}
+
+// NewWebSearchChunkStreaming is NewWebSearchStreaming returning a stream
+// that also decodes chat completion chunks into openai-go's types.
+func (c *Client) NewWebSearchChunkStreaming(ctx context.Context, params openai.ChatCompletionNewParams, opts WebSearchOptions, reqOpts ...option.RequestOption) (*WebSearchChunkStream, error) {
+	stream, err := c.NewWebSearchStreaming(ctx, params, opts, reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewWebSearchChunkStream(stream), nil
+}
@@ -0,0 +1,130 @@
+package tinfoil
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+)
+
+const chunkTestStream = `data: {"type":"web_search_call","id":"ws_1","status":"in_progress","action":{"type":"search","query":"go news"}}
+
+data: {"type":"web_search_call","id":"ws_1","status":"completed","action":{"type":"search","query":"go news"}}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1760000000,"model":"gpt-oss-120b","choices":[{"index":0,"delta":{"role":"assistant","search_reasoning":"Checking news.","annotations":[{"type":"url_citation","url_citation":{"title":"Go","url":"https://go.dev"}}]}}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1760000000,"model":"gpt-oss-120b","choices":[{"index":0,"delta":{"content":"Go 1.25."},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+
+func TestWebSearchEventFromChunk(t *testing.T) {
+	var chunk openai.ChatCompletionChunk
+	if err := json.Unmarshal([]byte(`{"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Hi","search_reasoning":"r","annotations":[{"type":"url_citation","url_citation":{"title":"Go","url":"https://go.dev"}}]}}]}`), &chunk); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	event, err := WebSearchEventFromChunk(chunk)
+	if err != nil {
+		t.Fatalf("WebSearchEventFromChunk failed: %v", err)
+	}
+	if event.Kind() != WebSearchEventChunk || len(event.Choices) != 1 {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+	delta := event.Choices[0].Delta
+	if delta.SearchReasoning != "r" || len(delta.Annotations) != 1 || delta.Annotations[0].URLCitation.URL != "https://go.dev" {
+		t.Errorf("Delta mismatch: %+v", delta)
+	}
+
+	fromDelta, err := WebSearchDeltaFromChunk(chunk.Choices[0].Delta)
+	if err != nil {
+		t.Fatalf("WebSearchDeltaFromChunk failed: %v", err)
+	}
+	if fromDelta.Content != "Hi" || fromDelta.SearchReasoning != "r" || len(fromDelta.Annotations) != 1 {
+		t.Errorf("Delta mismatch: %+v", fromDelta)
+	}
+
+	if _, err := WebSearchEventFromChunk(openai.ChatCompletionChunk{}); err == nil {
+		t.Error("Expected an error for a chunk without raw JSON")
+	}
+}
+
+func TestWebSearchEventFromOpenAIStream(t *testing.T) {
+	var bodies []map[string]any
+	c := newWebSearchTestClient(t, &bodies, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, chunkTestStream)
+	})
+
+	stream := c.Chat.Completions.NewStreaming(context.Background(), webSearchTestParams)
+	defer stream.Close()
+
+	var acc WebSearchAccumulator
+	for stream.Next() {
+		event, err := WebSearchEventFromChunk(stream.Current())
+		if err != nil {
+			t.Fatalf("WebSearchEventFromChunk failed: %v", err)
+		}
+		acc.AddEvent(event)
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+
+	if len(acc.Searches) != 1 || acc.Searches[0].Status != WebSearchStatusCompleted {
+		t.Errorf("Searches mismatch: %+v", acc.Searches)
+	}
+	if acc.Content != "Go 1.25." || acc.SearchReasoning != "Checking news." || len(acc.Annotations) != 1 {
+		t.Errorf("Accumulated message mismatch: %+v", acc.WebSearchMessage)
+	}
+}
+
+func TestWebSearchChunkStream(t *testing.T) {
+	var bodies []map[string]any
+	c := newWebSearchTestClient(t, &bodies, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, chunkTestStream)
+	})
+
+	stream, err := c.NewWebSearchChunkStreaming(context.Background(), webSearchTestParams, WebSearchOptions{Enabled: true})
+	if err != nil {
+		t.Fatalf("NewWebSearchChunkStreaming failed: %v", err)
+	}
+	defer stream.Close()
+
+	var completion openai.ChatCompletionAccumulator
+	var searches, chunks int
+	for stream.Next() {
+		if call := stream.SearchCall(); call != nil {
+			searches++
+			continue
+		}
+		chunk, ok := stream.Chunk()
+		if !ok {
+			t.Fatal("Expected a chunk")
+		}
+		chunks++
+		completion.AddChunk(chunk)
+
+		if annotations := stream.Current().Choices[0].Delta.Annotations; chunks == 1 && len(annotations) != 1 {
+			t.Errorf("Expected web search annotations on the first chunk, got %+v", annotations)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+
+	if searches != 2 || chunks != 2 {
+		t.Errorf("Expected 2 searches and 2 chunks, got %d and %d", searches, chunks)
+	}
+	if completion.Choices[0].Message.Content != "Go 1.25." || completion.Model != "gpt-oss-120b" {
+		t.Errorf("Accumulated completion mismatch: %+v", completion.ChatCompletion)
+	}
+	if stream.Summary().Completed != 1 {
+		t.Errorf("Summary mismatch: %+v", stream.Summary())
+	}
+}
@@ -0,0 +1,121 @@
+package tinfoil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+)
+
+// WebSearchCompletion is the result of a non-streaming web search request:
+// the standard chat completion together with its web search metadata.
+type WebSearchCompletion struct {
+	openai.ChatCompletion
+
+	// WebSearch is the web search metadata of the first choice.
+	WebSearch WebSearchMessage
+
+	// WebSearchChoices holds the web search metadata of every choice, where
+	// element i belongs to the choice with index i.
+	WebSearchChoices []WebSearchMessage
+}
+
+// streamOption is an option.RequestOption carrying the options of the
+// stream a streaming helper returns. Like clientOption it embeds a no-op
+// RequestOption, so it has no effect on requests made by openai-go.
+type streamOption[T any] struct {
+	option.RequestOption
+	opts T
+}
+
+func newStreamOption[T any](opts T) option.RequestOption {
+	return streamOption[T]{RequestOption: option.WithMiddleware(), opts: opts}
+}
+
+// takeStreamOptions removes the stream options of type T from reqOpts,
+// returning the last ones given.
+func takeStreamOptions[T any](reqOpts []option.RequestOption) (T, []option.RequestOption) {
+	var opts T
+	var rest []option.RequestOption
+	for _, o := range reqOpts {
+		if s, ok := o.(streamOption[T]); ok {
+			opts = s.opts
+			continue
+		}
+		rest = append(rest, o)
+	}
+	return opts, rest
+}
+
+// WithStreamOptions returns a request option that configures the stream
+// returned by NewWebSearchStreaming and NewWebSearchChunkStreaming.
+// BlockedSearchDefault, the zero BlockedSearchPolicy, selects the client's.
+func WithStreamOptions(opts WebSearchStreamOptions) option.RequestOption {
+	return newStreamOption(opts)
+}
+
+// NewWebSearchStreaming sends a streaming chat completion request with the
+// given web search options and returns a stream of web search and chunk
+// events. The stream stops when ctx is done, and is configured by
+// WithStreamOptions among reqOpts. Invalid options are rejected before
+// sending and non-2xx responses are returned as *openai.Error. Blocked
+// searches are handled by the client's BlockedSearchPolicy unless the stream
+// options set one. The caller must close the returned stream.
+func (c *Client) NewWebSearchStreaming(ctx context.Context, params openai.ChatCompletionNewParams, opts WebSearchOptions, reqOpts ...option.RequestOption) (*WebSearchStream, error) {
+	if err := opts.ApplyTo(&params); err != nil {
+		return nil, err
+	}
+	streamOpts, reqOpts := takeStreamOptions[WebSearchStreamOptions](reqOpts)
+	if streamOpts.BlockedSearchPolicy == BlockedSearchDefault {
+		streamOpts.BlockedSearchPolicy = c.blockedSearchPolicy
+	}
+
+	var resp *http.Response
+	reqOpts = append([]option.RequestOption{option.WithJSONSet("stream", true)}, reqOpts...)
+	if err := c.Post(ctx, "chat/completions", params, &resp, reqOpts...); err != nil {
+		return nil, err
+	}
+	return NewWebSearchStreamWithContext(ctx, resp.Body, streamOpts), nil
+}
+
+// NewWebSearch sends a chat completion request with the given web search
+// options and returns the completion with its web search metadata. Invalid
+// options are rejected before sending and non-2xx responses are returned as
+// *openai.Error. Blocked searches are handled by the client's
+// BlockedSearchPolicy; under BlockedSearchFail the completion is returned
+// together with the *BlockedSearchError.
+func (c *Client) NewWebSearch(ctx context.Context, params openai.ChatCompletionNewParams, opts WebSearchOptions, reqOpts ...option.RequestOption) (*WebSearchCompletion, error) {
+	if err := opts.ApplyTo(&params); err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if err := c.Post(ctx, "chat/completions", params, &body, reqOpts...); err != nil {
+		return nil, err
+	}
+
+	var completion WebSearchCompletion
+	if err := json.Unmarshal(body, &completion.ChatCompletion); err != nil {
+		return nil, fmt.Errorf("failed to parse chat completion: %w", err)
+	}
+	messages, err := ParseWebSearchMessages(body)
+	if err != nil {
+		return nil, err
+	}
+	completion.WebSearch = messages[0]
+	completion.WebSearchChoices = messages
+
+	var blocked []BlockedSearch
+	for _, message := range messages {
+		for _, search := range message.BlockedSearches {
+			blocked, _ = mergeBlockedSearch(blocked, search)
+		}
+	}
+	if err := c.blockedSearchPolicy.apply(blocked); err != nil {
+		return &completion, err
+	}
+	return &completion, nil
+}
@@ -0,0 +1,281 @@
+package tinfoil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+	"github.com/openai/openai-go/v3/responses"
+	"github.com/stretchr/testify/require"
+)
+
+// handlerTransport serves requests in-process with handler.
+func handlerTransport(handler http.HandlerFunc) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		resp := rec.Result()
+		resp.Request = req
+		return resp, nil
+	})
+}
+
+// newWebSearchTestClient returns a client whose requests are served by
+// handler, recording each decoded request body into bodies.
+func newWebSearchTestClient(t *testing.T, bodies *[]map[string]any, handler http.HandlerFunc) *Client {
+	t.Helper()
+	transport := handlerTransport(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		data, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(data, &body))
+		*bodies = append(*bodies, body)
+		handler(w, r)
+	})
+
+	c, err := NewClientWithVerifier(&fakeVerifier{transports: []http.RoundTripper{transport}},
+		option.WithAPIKey("test-key"), option.WithMaxRetries(0))
+	require.NoError(t, err)
+	return c
+}
+
+var webSearchTestParams = openai.ChatCompletionNewParams{
+	Model:    "gpt-oss-120b",
+	Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("What's new?")},
+}
+
+func TestClientNewWebSearchStreaming(t *testing.T) {
+	var bodies []map[string]any
+	c := newWebSearchTestClient(t, &bodies, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/chat/completions", r.URL.Path)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"type":"web_search_call","id":"ws_1","status":"completed","action":{"type":"search","query":"news"}}`+"\n\n")
+		io.WriteString(w, `data: {"choices":[{"index":0,"delta":{"content":"Hello"}}]}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	})
+
+	stream, err := c.NewWebSearchStreaming(context.Background(), webSearchTestParams,
+		WebSearchOptions{Enabled: true, SearchContextSize: SearchContextSizeHigh})
+	require.NoError(t, err)
+	defer stream.Close()
+
+	var acc WebSearchAccumulator
+	for stream.Next() {
+		require.True(t, acc.AddEvent(stream.Current()))
+	}
+	require.NoError(t, stream.Err())
+	require.Equal(t, "Hello", acc.Content)
+	require.Len(t, acc.Searches, 1)
+
+	require.Len(t, bodies, 1)
+	require.Equal(t, true, bodies[0]["stream"])
+	require.Equal(t, map[string]any{"search_context_size": "high"}, bodies[0]["web_search_options"])
+}
+
+func TestClientNewWebSearchStreamingOptions(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"type":"web_search_call","id":"ws_1","status":"blocked","reason":"PII"}`+"\n\n")
+		io.WriteString(w, `data: {"choices":[{"index":0,"delta":{"content":"`+strings.Repeat("a", 1024)+`"}}]}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}
+	c, err := NewClientWithVerifier(&fakeVerifier{transports: []http.RoundTripper{handlerTransport(handler)}},
+		option.WithAPIKey("test-key"), option.WithMaxRetries(0), WithBlockedSearchPolicy(BlockedSearchFail))
+	require.NoError(t, err)
+
+	// The stream inherits the client's blocked search policy
+	stream, err := c.NewWebSearchStreaming(context.Background(), webSearchTestParams, WebSearchOptions{Enabled: true})
+	require.NoError(t, err)
+	for stream.Next() {
+	}
+	stream.Close()
+	var blockedErr *BlockedSearchError
+	require.ErrorAs(t, stream.Err(), &blockedErr)
+
+	// An explicit BlockedSearchAllow overrides it
+	stream, err = c.NewWebSearchStreaming(context.Background(), webSearchTestParams, WebSearchOptions{Enabled: true},
+		WithStreamOptions(WebSearchStreamOptions{BlockedSearchPolicy: BlockedSearchAllow}))
+	require.NoError(t, err)
+	for stream.Next() {
+	}
+	stream.Close()
+	require.NoError(t, stream.Err())
+	require.Equal(t, 1, stream.Summary().Blocked)
+
+	// Stream options set through the helper apply, including their own policy
+	stream, err = c.NewWebSearchStreaming(context.Background(), webSearchTestParams, WebSearchOptions{Enabled: true},
+		WithStreamOptions(WebSearchStreamOptions{MaxEventSize: 512, BlockedSearchPolicy: BlockedSearchWarn}))
+	require.NoError(t, err)
+	defer stream.Close()
+	require.True(t, stream.Next())
+	require.False(t, stream.Next())
+	require.ErrorIs(t, stream.Err(), ErrEventTooLarge)
+}
+
+func TestClientNewWebSearch(t *testing.T) {
+	var bodies []map[string]any
+	c := newWebSearchTestClient(t, &bodies, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{
+			"id": "chatcmpl-1",
+			"object": "chat.completion",
+			"model": "gpt-oss-120b",
+			"choices": [{
+				"index": 0,
+				"finish_reason": "stop",
+				"message": {
+					"role": "assistant",
+					"content": "Hello",
+					"annotations": [{"type": "url_citation", "url_citation": {"title": "Example", "url": "https://example.com"}}],
+					"blocked_searches": [{"id": "ws_2", "query": "my ssn", "reason": "pii"}]
+				}
+			}]
+		}`)
+	})
+
+	result, err := c.NewWebSearch(context.Background(), webSearchTestParams, WebSearchOptions{Enabled: true})
+	require.NoError(t, err)
+	require.Equal(t, "chatcmpl-1", result.ID)
+	require.Equal(t, "Hello", result.Choices[0].Message.Content)
+	require.Equal(t, "Hello", result.WebSearch.Content)
+	require.Len(t, result.WebSearch.Annotations, 1)
+	require.Equal(t, "https://example.com", result.WebSearch.Annotations[0].URLCitation.URL)
+	require.Len(t, result.WebSearch.BlockedSearches, 1)
+
+	require.Len(t, bodies, 1)
+	require.NotContains(t, bodies[0], "stream")
+	require.Equal(t, map[string]any{}, bodies[0]["web_search_options"])
+}
+
+func TestClientNewWebSearchErrorStatus(t *testing.T) {
+	var bodies []map[string]any
+	c := newWebSearchTestClient(t, &bodies, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":{"message":"web search unavailable","type":"invalid_request_error"}}`)
+	})
+
+	_, err := c.NewWebSearchStreaming(context.Background(), webSearchTestParams, WebSearchOptions{Enabled: true})
+	var apiErr *openai.Error
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	_, err = c.NewWebSearch(context.Background(), webSearchTestParams, WebSearchOptions{Enabled: true})
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+}
+
+func TestClientNewWebSearchInvalidOptions(t *testing.T) {
+	var bodies []map[string]any
+	c := newWebSearchTestClient(t, &bodies, func(w http.ResponseWriter, r *http.Request) {})
+
+	_, err := c.NewWebSearchStreaming(context.Background(), webSearchTestParams, WebSearchOptions{Enabled: true, MaxSearches: -1})
+	require.ErrorIs(t, err, ErrInvalidWebSearchOptions)
+	_, err = c.NewWebSearch(context.Background(), webSearchTestParams, WebSearchOptions{SearchContextSize: SearchContextSizeLow})
+	require.ErrorIs(t, err, ErrInvalidWebSearchOptions)
+	require.Empty(t, bodies)
+}
+
+func TestClientNewWebSearchBlockedSearchPolicy(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{
+			"id": "chatcmpl-1",
+			"object": "chat.completion",
+			"choices": [{
+				"index": 0,
+				"finish_reason": "stop",
+				"message": {
+					"role": "assistant",
+					"content": "I cannot search for that.",
+					"blocked_searches": [{"id": "ws_1", "query": "my ssn 123-45-6789", "reason": "SSN detected"}]
+				}
+			}]
+		}`)
+	}
+
+	var bodies []map[string]any
+	c := newWebSearchTestClient(t, &bodies, handler)
+	result, err := c.NewWebSearch(context.Background(), webSearchTestParams, WebSearchOptions{Enabled: true})
+	require.NoError(t, err)
+	require.Len(t, result.WebSearch.BlockedSearches, 1)
+
+	strict, err := NewClientWithVerifier(&fakeVerifier{transports: []http.RoundTripper{handlerTransport(handler)}},
+		option.WithAPIKey("test-key"), option.WithMaxRetries(0), WithBlockedSearchPolicy(BlockedSearchFail))
+	require.NoError(t, err)
+
+	// The completion is returned along with the error
+	result, err = strict.NewWebSearch(context.Background(), webSearchTestParams, WebSearchOptions{Enabled: true})
+	var blockedErr *BlockedSearchError
+	require.ErrorAs(t, err, &blockedErr)
+	require.Equal(t, "ws_1", blockedErr.Searches[0].ID)
+	require.Equal(t, "I cannot search for that.", result.Choices[0].Message.Content)
+
+	_, err = NewClientWithVerifier(&fakeVerifier{}, WithBlockedSearchPolicy(BlockedSearchPolicy(-1)))
+	require.Error(t, err)
+}
+
+func TestClientNewResponseWebSearchStreaming(t *testing.T) {
+	var bodies []map[string]any
+	c := newWebSearchTestClient(t, &bodies, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/responses", r.URL.Path)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"type":"response.web_search_call.completed","item_id":"ws_1"}`+"\n\n")
+		io.WriteString(w, `data: {"type":"response.output_text.delta","delta":"Hello"}`+"\n\n")
+	})
+
+	params := responses.ResponseNewParams{
+		Model: "gpt-oss-120b",
+		Input: responses.ResponseNewParamsInputUnion{OfString: openai.String("What's new?")},
+	}
+	stream, err := c.NewResponseWebSearchStreaming(context.Background(), params,
+		WebSearchOptions{Enabled: true, AllowedDomains: []string{"example.com"}})
+	require.NoError(t, err)
+	defer stream.Close()
+
+	var acc ResponseAccumulator
+	for stream.Next() {
+		require.True(t, acc.AddEvent(stream.Current()))
+	}
+	require.NoError(t, stream.Err())
+	require.Equal(t, "Hello", acc.Content)
+	require.Len(t, acc.Searches, 1)
+
+	require.Len(t, bodies, 1)
+	require.Equal(t, true, bodies[0]["stream"])
+	require.Equal(t, []any{map[string]any{
+		"type":    "web_search",
+		"filters": map[string]any{"allowed_domains": []any{"example.com"}},
+	}}, bodies[0]["tools"])
+}
+
+func TestClientNewResponseWebSearchStreamingOptions(t *testing.T) {
+	var bodies []map[string]any
+	c := newWebSearchTestClient(t, &bodies, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"type":"response.output_text.delta","delta":"Hello"}`+"\n\n")
+		io.WriteString(w, `data: {"type":"response.output_text.delta","delta":"`+strings.Repeat("a", 1024)+`"}`+"\n\n")
+	})
+
+	params := responses.ResponseNewParams{
+		Model: "gpt-oss-120b",
+		Input: responses.ResponseNewParamsInputUnion{OfString: openai.String("What's new?")},
+	}
+	stream, err := c.NewResponseWebSearchStreaming(context.Background(), params, WebSearchOptions{Enabled: true},
+		WithResponseStreamOptions(ResponseStreamOptions{MaxEventSize: 512}))
+	require.NoError(t, err)
+	defer stream.Close()
+	require.True(t, stream.Next())
+	require.False(t, stream.Next())
+	require.ErrorIs(t, stream.Err(), ErrEventTooLarge)
+
+	// The stream option is not sent to the server
+	require.Len(t, bodies, 1)
+	require.Equal(t, true, bodies[0]["stream"])
+}
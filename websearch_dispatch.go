@@ -0,0 +1,142 @@
+package tinfoil
+
+// WebSearchHandler receives the events of a web search stream from Dispatch.
+// Embed NopWebSearchHandler to implement only the methods of interest.
+type WebSearchHandler interface {
+	// OnSearchStarted is called once when a search first reports being in progress.
+	OnSearchStarted(search *WebSearchCall)
+	// OnSearchCompleted is called once when a search completes or fails;
+	// check search.Status to tell them apart.
+	OnSearchCompleted(search *WebSearchCall)
+	// OnSearchBlocked is called once when a search is blocked, e.g. because
+	// its query contained personally identifiable information.
+	OnSearchBlocked(search *WebSearchCall)
+	// OnCitation is called for each URL citation of the first choice.
+	OnCitation(citation URLCitation)
+	// OnContent is called for each content delta of the first choice.
+	OnContent(delta string)
+	// OnReasoning is called for each search reasoning delta and reasoning
+	// items of the first choice.
+	OnReasoning(reasoning string, items []ReasoningItem)
+	// OnFinish is called when the first choice finishes.
+	OnFinish(reason string)
+}
+
+// NopWebSearchHandler implements WebSearchHandler with methods that do nothing.
+type NopWebSearchHandler struct{}
+
+func (NopWebSearchHandler) OnSearchStarted(*WebSearchCall)      {}
+func (NopWebSearchHandler) OnSearchCompleted(*WebSearchCall)    {}
+func (NopWebSearchHandler) OnSearchBlocked(*WebSearchCall)      {}
+func (NopWebSearchHandler) OnCitation(URLCitation)              {}
+func (NopWebSearchHandler) OnContent(string)                    {}
+func (NopWebSearchHandler) OnReasoning(string, []ReasoningItem) {}
+func (NopWebSearchHandler) OnFinish(string)                     {}
+
+// Dispatch reads the stream to the end, calling the handler for each event,
+// and returns the stream's error. Search callbacks receive the search with
+// every update seen so far merged in, so a blocked search keeps the query of
+// its in-progress event. Searches reported blocked in a delta's
+// blocked_searches are passed to OnSearchBlocked too, once per ID, unless the
+// search already completed or failed. Events of unknown kinds are skipped.
+// The stream is closed when Dispatch returns.
+func Dispatch(stream *WebSearchStream, handler WebSearchHandler) error {
+	var acc WebSearchAccumulator
+	started := make(map[string]bool)
+	completed := make(map[string]bool)
+	blocked := make(map[string]bool)
+
+	for event, err := range stream.All() {
+		if err != nil {
+			return err
+		}
+		if !acc.AddEvent(event) {
+			continue
+		}
+
+		if call := event.ToWebSearchCall(); call != nil {
+			search := acc.Searches[acc.searchIndex[call.ID]]
+			if call.Status == WebSearchStatusInProgress && !started[call.ID] {
+				started[call.ID] = true
+				handler.OnSearchStarted(&search)
+			}
+			if finished, ok := acc.JustFinishedSearch(); ok {
+				if finished.Status.IsBlocked() {
+					if !blocked[finished.ID] {
+						blocked[finished.ID] = true
+						handler.OnSearchBlocked(&finished)
+					}
+				} else if !completed[finished.ID] {
+					completed[finished.ID] = true
+					handler.OnSearchCompleted(&finished)
+				}
+			}
+			continue
+		}
+
+		for _, choice := range event.Choices {
+			// Blocked searches apply to the whole request, whichever choice
+			// reports them
+			if delta := choice.Delta; delta != nil {
+				for _, search := range delta.BlockedSearches {
+					if search.ID != "" && blocked[search.ID] || acc.searchEndedUnblocked(search.ID) {
+						continue
+					}
+					blocked[search.ID] = true
+					call := acc.blockedSearchCall(search)
+					handler.OnSearchBlocked(&call)
+				}
+			}
+			if choice.Index != 0 {
+				continue
+			}
+			if delta := choice.Delta; delta != nil {
+				if delta.SearchReasoning != "" || len(delta.ReasoningItems) > 0 {
+					handler.OnReasoning(delta.SearchReasoning, delta.ReasoningItems)
+				}
+				for _, annotation := range delta.Annotations {
+					if annotation.Type == "url_citation" {
+						handler.OnCitation(annotation.URLCitation)
+					}
+				}
+				if delta.Content != "" {
+					handler.OnContent(delta.Content)
+				}
+			}
+			if choice.FinishReason != "" {
+				handler.OnFinish(choice.FinishReason)
+			}
+		}
+	}
+	return nil
+}
+
+// blockedSearchCall returns the search call of a search reported in a delta's
+// blocked_searches, merged with what the accumulator saw of it.
+func (acc *WebSearchAccumulator) blockedSearchCall(search BlockedSearch) WebSearchCall {
+	for _, merged := range acc.BlockedSearches {
+		if search.ID != "" && merged.ID == search.ID {
+			search = merged
+			break
+		}
+	}
+
+	call := WebSearchCall{Type: "web_search_call", ID: search.ID, Reason: search.Reason}
+	if i, ok := acc.searchIndex[search.ID]; ok && search.ID != "" {
+		call = acc.Searches[i]
+		if call.Reason == "" {
+			call.Reason = search.Reason
+		}
+	}
+	call.Status = WebSearchStatusBlocked
+	if call.Action == nil {
+		call.Action = &WebSearchAction{Type: "search"}
+	} else {
+		action := *call.Action
+		call.Action = &action
+	}
+	if call.Action.Query == "" {
+		call.Action.Query = search.Query
+	}
+	return call
+}
@@ -0,0 +1,195 @@
+package tinfoil
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// recordingHandler records every callback as a line of text.
+type recordingHandler struct {
+	NopWebSearchHandler
+	calls []string
+}
+
+func (h *recordingHandler) OnSearchStarted(search *WebSearchCall) {
+	h.calls = append(h.calls, fmt.Sprintf("started %s %s", search.ID, search.Action.Query))
+}
+
+func (h *recordingHandler) OnSearchCompleted(search *WebSearchCall) {
+	h.calls = append(h.calls, fmt.Sprintf("%s %s", search.Status, search.ID))
+}
+
+func (h *recordingHandler) OnSearchBlocked(search *WebSearchCall) {
+	h.calls = append(h.calls, fmt.Sprintf("blocked %s %s: %s", search.ID, search.Action.Query, search.Reason))
+}
+
+func (h *recordingHandler) OnCitation(citation URLCitation) {
+	h.calls = append(h.calls, "citation "+citation.URL)
+}
+
+func (h *recordingHandler) OnContent(delta string) {
+	h.calls = append(h.calls, "content "+delta)
+}
+
+func (h *recordingHandler) OnReasoning(reasoning string, items []ReasoningItem) {
+	h.calls = append(h.calls, fmt.Sprintf("reasoning %q %d", reasoning, len(items)))
+}
+
+func (h *recordingHandler) OnFinish(reason string) {
+	h.calls = append(h.calls, "finish "+reason)
+}
+
+func TestDispatch(t *testing.T) {
+	sseData := `data: {"type":"web_search_call","id":"ws_1","status":"in_progress","action":{"type":"search","query":"go news"}}
+
+data: {"type":"web_search_call","id":"ws_1","status":"in_progress","action":{"type":"search","query":"go news"}}
+
+data: {"type":"web_search_call","id":"ws_2","status":"in_progress","action":{"type":"search","query":"my ssn"}}
+
+data: {"type":"web_search_call","id":"ws_3","status":"in_progress","action":{"type":"search","query":"go blog"}}
+
+data: {"type":"web_search_call","id":"ws_1","status":"completed","action":{"type":"search","query":"go news"}}
+
+data: {"type":"web_search_call","id":"ws_2","status":"blocked","reason":"SSN detected"}
+
+data: {"type":"web_search_call","id":"ws_3","status":"failed","reason":"timeout"}
+
+data: {"type":"web_search_results","id":"wsr_1"}
+
+data: {"choices":[{"index":0,"delta":{"search_reasoning":"Looking","reasoning_items":[{"id":"r_1","type":"reasoning"}],"annotations":[{"type":"url_citation","url_citation":{"title":"Go","url":"https://go.dev"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"Go "}},{"index":1,"delta":{"content":"ignored"}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"1.25"},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+
+	stream := SimulateWebSearchStream(sseData)
+	handler := &recordingHandler{}
+	if err := Dispatch(stream, handler); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+
+	want := []string{
+		"started ws_1 go news",
+		"started ws_2 my ssn",
+		"started ws_3 go blog",
+		"completed ws_1",
+		"blocked ws_2 my ssn: SSN detected",
+		"failed ws_3",
+		`reasoning "Looking" 1`,
+		"citation https://go.dev",
+		"content Go ",
+		"content 1.25",
+		"finish stop",
+	}
+	if !reflect.DeepEqual(handler.calls, want) {
+		t.Errorf("Unexpected callbacks:\n got: %q\nwant: %q", handler.calls, want)
+	}
+}
+
+func TestDispatchDeltaBlockedSearches(t *testing.T) {
+	sseData := `data: {"type":"web_search_call","id":"ws_1","status":"in_progress","action":{"type":"search","query":"my ssn"}}
+
+data: {"choices":[{"index":0,"delta":{"blocked_searches":[{"id":"ws_1","query":"","reason":"SSN detected"}]}}]}
+
+data: {"type":"web_search_call","id":"ws_1","status":"blocked","reason":"SSN detected"}
+
+data: {"choices":[{"index":1,"delta":{"blocked_searches":[{"id":"ws_2","query":"my card","reason":"card number"}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"Sorry","blocked_searches":[{"id":"ws_2","query":"my card"}]}}]}
+
+data: [DONE]
+
+`
+
+	stream := SimulateWebSearchStream(sseData)
+	handler := &recordingHandler{}
+	if err := Dispatch(stream, handler); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+
+	want := []string{
+		"started ws_1 my ssn",
+		"blocked ws_1 my ssn: SSN detected",
+		"blocked ws_2 my card: card number",
+		"content Sorry",
+	}
+	if !reflect.DeepEqual(handler.calls, want) {
+		t.Errorf("Unexpected callbacks:\n got: %q\nwant: %q", handler.calls, want)
+	}
+}
+
+func TestDispatchRepeatedSearchEvents(t *testing.T) {
+	sseData := `data: {"type":"web_search_call","id":"ws_1","status":"in_progress","action":{"type":"search","query":"go news"}}
+
+data: {"type":"web_search_call","id":"ws_1","status":"completed"}
+
+data: {"type":"web_search_call","id":"ws_2","status":"in_progress","action":{"type":"search","query":"go blog"}}
+
+data: {"type":"web_search_call","id":"ws_1","status":"in_progress"}
+
+data: {"type":"web_search_call","id":"ws_1","status":"completed"}
+
+data: {"type":"web_search_call","id":"ws_2","status":"completed"}
+
+data: {"type":"web_search_call","id":"ws_2","status":"blocked","reason":"PII"}
+
+data: [DONE]
+
+`
+
+	stream := SimulateWebSearchStream(sseData)
+	handler := &recordingHandler{}
+	if err := Dispatch(stream, handler); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+
+	want := []string{
+		"started ws_1 go news",
+		"completed ws_1",
+		"started ws_2 go blog",
+		"completed ws_2",
+	}
+	if !reflect.DeepEqual(handler.calls, want) {
+		t.Errorf("Unexpected callbacks:\n got: %q\nwant: %q", handler.calls, want)
+	}
+}
+
+func TestDispatchBlockedAfterCompleted(t *testing.T) {
+	stream := SimulateWebSearchStream(blockedAfterCompletedStream)
+	handler := &recordingHandler{}
+	if err := Dispatch(stream, handler); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+
+	want := []string{
+		"started ws_1 go news",
+		"completed ws_1",
+	}
+	if !reflect.DeepEqual(handler.calls, want) {
+		t.Errorf("Unexpected callbacks:\n got: %q\nwant: %q", handler.calls, want)
+	}
+}
+
+func TestDispatchError(t *testing.T) {
+	stream := SimulateWebSearchStream("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\ndata: {invalid\n\n")
+	handler := &recordingHandler{}
+
+	err := Dispatch(stream, handler)
+	if err == nil {
+		t.Fatal("Expected the stream error")
+	}
+	if len(handler.calls) != 1 || handler.calls[0] != "content Hi" {
+		t.Errorf("Unexpected callbacks before the error: %q", handler.calls)
+	}
+
+	var aborted *StreamAbortedError
+	if errors.As(err, &aborted) {
+		t.Errorf("Expected a parse error, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package tinfoil
+
+import "iter"
+
+// All returns an iterator over the remaining events of the stream. If the
+// stream fails, the final pair holds a nil event and the error. The stream is
+// closed when iteration ends, including when the loop exits early.
+func (s *WebSearchStream) All() iter.Seq2[*WebSearchStreamEvent, error] {
+	return func(yield func(*WebSearchStreamEvent, error) bool) {
+		defer s.Close()
+		for s.Next() {
+			if !yield(s.Current(), nil) {
+				return
+			}
+		}
+		if err := s.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// SearchCalls returns an iterator over the web search call events of the
+// stream, skipping chat completion chunks. It closes the stream like All.
+func (s *WebSearchStream) SearchCalls() iter.Seq2[*WebSearchCall, error] {
+	return func(yield func(*WebSearchCall, error) bool) {
+		for event, err := range s.All() {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if call := event.ToWebSearchCall(); call != nil && !yield(call, nil) {
+				return
+			}
+		}
+	}
+}
+
+// ContentDeltas returns an iterator over the non-empty content deltas of the
+// first choice. It closes the stream like All.
+func (s *WebSearchStream) ContentDeltas() iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for delta, err := range s.firstChoiceDeltas() {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			if delta.Content != "" && !yield(delta.Content, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Annotations returns an iterator over the annotations of the first choice as
+// they arrive. It closes the stream like All.
+func (s *WebSearchStream) Annotations() iter.Seq2[Annotation, error] {
+	return func(yield func(Annotation, error) bool) {
+		for delta, err := range s.firstChoiceDeltas() {
+			if err != nil {
+				yield(Annotation{}, err)
+				return
+			}
+			for _, annotation := range delta.Annotations {
+				if !yield(annotation, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// firstChoiceDeltas returns an iterator over the deltas of the first choice.
+func (s *WebSearchStream) firstChoiceDeltas() iter.Seq2[*WebSearchDelta, error] {
+	return func(yield func(*WebSearchDelta, error) bool) {
+		for event, err := range s.All() {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, choice := range event.Choices {
+				if choice.Index == 0 && choice.Delta != nil && !yield(choice.Delta, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package tinfoil
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+const iterTestStream = `data: {"type":"web_search_call","id":"ws_1","status":"in_progress","action":{"type":"search","query":"go iterators"}}
+
+data: {"type":"web_search_call","id":"ws_1","status":"completed","action":{"type":"search","query":"go iterators"}}
+
+data: {"choices":[{"index":0,"delta":{"annotations":[{"type":"url_citation","url_citation":{"title":"Go","url":"https://go.dev"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"Range "}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"over func."}}]}
+
+data: [DONE]
+
+`
+
+// closeTracker records whether the stream body was closed.
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newIterTestStream(data string) (*WebSearchStream, *closeTracker) {
+	body := &closeTracker{Reader: strings.NewReader(data)}
+	return NewWebSearchStream(body), body
+}
+
+func TestStreamAll(t *testing.T) {
+	stream, body := newIterTestStream(iterTestStream)
+
+	events := 0
+	for event, err := range stream.All() {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if event == nil {
+			t.Fatal("Expected an event")
+		}
+		events++
+	}
+	if events != 5 {
+		t.Errorf("Expected 5 events, got %d", events)
+	}
+	if !body.closed {
+		t.Error("Expected the body to be closed")
+	}
+}
+
+func TestStreamAllBreakClosesBody(t *testing.T) {
+	stream, body := newIterTestStream(iterTestStream)
+
+	for range stream.All() {
+		break
+	}
+	if !body.closed {
+		t.Error("Expected the body to be closed after break")
+	}
+	if stream.Next() {
+		t.Error("Expected no more events after break")
+	}
+}
+
+func TestStreamAllError(t *testing.T) {
+	stream, _ := newIterTestStream("data: {\"choices\":[]}\n\ndata: {invalid\n\n")
+
+	var events int
+	var lastErr error
+	for event, err := range stream.All() {
+		if err != nil {
+			if event != nil {
+				t.Error("Expected a nil event with the error")
+			}
+			lastErr = err
+			continue
+		}
+		events++
+	}
+	if events != 1 {
+		t.Errorf("Expected 1 event before the error, got %d", events)
+	}
+	if lastErr == nil {
+		t.Error("Expected the parse error to be yielded")
+	}
+}
+
+func TestStreamSearchCalls(t *testing.T) {
+	stream, _ := newIterTestStream(iterTestStream)
+
+	var statuses []WebSearchStatus
+	for call, err := range stream.SearchCalls() {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		statuses = append(statuses, call.Status)
+	}
+	if len(statuses) != 2 || statuses[0] != WebSearchStatusInProgress || statuses[1] != WebSearchStatusCompleted {
+		t.Errorf("Unexpected statuses: %v", statuses)
+	}
+}
+
+func TestStreamContentDeltas(t *testing.T) {
+	stream, _ := newIterTestStream(iterTestStream)
+
+	var content strings.Builder
+	for delta, err := range stream.ContentDeltas() {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		content.WriteString(delta)
+	}
+	if content.String() != "Range over func." {
+		t.Errorf("Expected 'Range over func.', got %q", content.String())
+	}
+}
+
+func TestStreamContentDeltasBreakClosesBody(t *testing.T) {
+	stream, body := newIterTestStream(iterTestStream)
+
+	for delta := range stream.ContentDeltas() {
+		if delta != "Range " {
+			t.Errorf("Expected first delta 'Range ', got %q", delta)
+		}
+		break
+	}
+	if !body.closed {
+		t.Error("Expected the body to be closed after break")
+	}
+}
+
+func TestStreamAnnotations(t *testing.T) {
+	stream, _ := newIterTestStream(iterTestStream)
+
+	var urls []string
+	for annotation, err := range stream.Annotations() {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		urls = append(urls, annotation.URLCitation.URL)
+	}
+	if len(urls) != 1 || urls[0] != "https://go.dev" {
+		t.Errorf("Unexpected annotations: %v", urls)
+	}
+}
+
+func TestStreamIteratorAborted(t *testing.T) {
+	stream, _ := newIterTestStream(iterTestStream)
+	stream.events.abort(ErrStreamIdle)
+
+	var errs []error
+	for _, err := range stream.SearchCalls() {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrStreamIdle) {
+		t.Errorf("Expected a single ErrStreamIdle, got %v", errs)
+	}
+}
@@ -0,0 +1,169 @@
+package tinfoil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/packages/param"
+)
+
+// Search context sizes accepted by WebSearchOptions.SearchContextSize.
+const (
+	SearchContextSizeLow    = "low"
+	SearchContextSizeMedium = "medium"
+	SearchContextSizeHigh   = "high"
+)
+
+// ErrInvalidWebSearchOptions is returned when WebSearchOptions fail validation.
+var ErrInvalidWebSearchOptions = errors.New("invalid web search options")
+
+// WebSearchOptions configures web search for a chat completion request. They
+// are sent as the request's "web_search_options" field.
+type WebSearchOptions struct {
+	// Enabled requests web search. When false no web search options are sent
+	// and every other field must be left unset.
+	Enabled bool `json:"-"`
+
+	// SearchContextSize is the amount of search context given to the model:
+	// SearchContextSizeLow, SearchContextSizeMedium or SearchContextSizeHigh.
+	// Empty selects the server default.
+	SearchContextSize string `json:"search_context_size,omitempty"`
+
+	// UserLocation approximates the user's location to localize results.
+	UserLocation *WebSearchUserLocation `json:"user_location,omitempty"`
+
+	// AllowedDomains restricts results to these domains and their subdomains.
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+
+	// BlockedDomains excludes results from these domains and their subdomains.
+	BlockedDomains []string `json:"blocked_domains,omitempty"`
+
+	// PIIFilter toggles blocking of searches whose query contains personally
+	// identifiable information. Nil selects the server default.
+	PIIFilter *bool `json:"pii_filter,omitempty"`
+
+	// MaxSearches limits the number of searches the model may run. Zero
+	// selects the server default.
+	MaxSearches int `json:"max_searches,omitempty"`
+}
+
+// WebSearchUserLocation is an approximate user location. All fields are
+// optional free text, except Country which is a two-letter ISO 3166-1 code.
+type WebSearchUserLocation struct {
+	City     string `json:"city,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Country  string `json:"country,omitempty"`
+	Timezone string `json:"timezone,omitempty"` // IANA time zone, e.g. "America/New_York"
+}
+
+// MarshalJSON encodes the location in the chat completions API's
+// approximate location format.
+func (l WebSearchUserLocation) MarshalJSON() ([]byte, error) {
+	type approximate WebSearchUserLocation
+	return json.Marshal(struct {
+		Type        string      `json:"type"`
+		Approximate approximate `json:"approximate"`
+	}{
+		Type:        "approximate",
+		Approximate: approximate(l),
+	})
+}
+
+// Validate reports whether the options can be sent. Errors wrap
+// ErrInvalidWebSearchOptions.
+func (o WebSearchOptions) Validate() error {
+	if !o.Enabled {
+		if o.SearchContextSize != "" || o.UserLocation != nil || len(o.AllowedDomains) > 0 ||
+			len(o.BlockedDomains) > 0 || o.PIIFilter != nil || o.MaxSearches != 0 {
+			return invalidWebSearchOptions("options are set but web search is not enabled")
+		}
+		return nil
+	}
+
+	switch o.SearchContextSize {
+	case "", SearchContextSizeLow, SearchContextSizeMedium, SearchContextSizeHigh:
+	default:
+		return invalidWebSearchOptions("unknown search context size %q", o.SearchContextSize)
+	}
+
+	if o.MaxSearches < 0 {
+		return invalidWebSearchOptions("max searches must not be negative, got %d", o.MaxSearches)
+	}
+
+	if l := o.UserLocation; l != nil && l.Country != "" && !isCountryCode(l.Country) {
+		return invalidWebSearchOptions("user location country %q is not a two-letter ISO code", l.Country)
+	}
+
+	allowed := make(map[string]bool, len(o.AllowedDomains))
+	for _, domain := range o.AllowedDomains {
+		if err := validateDomain(domain); err != nil {
+			return err
+		}
+		allowed[strings.ToLower(domain)] = true
+	}
+	for _, domain := range o.BlockedDomains {
+		if err := validateDomain(domain); err != nil {
+			return err
+		}
+		if allowed[strings.ToLower(domain)] {
+			return invalidWebSearchOptions("domain %q is both allowed and blocked", domain)
+		}
+	}
+
+	return nil
+}
+
+// ApplyTo validates the options and sets them as the web_search_options
+// field of params with openai-go's SetExtraFields, keeping any other extra
+// fields. It leaves params unchanged when web search is not enabled, and
+// fails rather than replace web search options params already sets.
+func (o WebSearchOptions) ApplyTo(params *openai.ChatCompletionNewParams) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if !o.Enabled {
+		return nil
+	}
+
+	extras := params.ExtraFields()
+	if _, ok := extras["web_search_options"]; ok || !param.IsOmitted(params.WebSearchOptions) {
+		return errors.New("web_search_options is already set on the request params")
+	}
+	fields := make(map[string]any, len(extras)+1)
+	maps.Copy(fields, extras)
+	fields["web_search_options"] = o
+	params.SetExtraFields(fields)
+	return nil
+}
+
+func invalidWebSearchOptions(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidWebSearchOptions, fmt.Sprintf(format, args...))
+}
+
+// validateDomain checks that domain is a bare host name, without a scheme,
+// port or path.
+func validateDomain(domain string) error {
+	if domain == "" {
+		return invalidWebSearchOptions("empty domain")
+	}
+	if strings.ContainsAny(domain, ":/?#@ \t") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return invalidWebSearchOptions("domain %q must be a bare host name such as example.com", domain)
+	}
+	return nil
+}
+
+func isCountryCode(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package tinfoil
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+)
+
+func TestWebSearchOptionsJSON(t *testing.T) {
+	piiFilter := false
+	opts := WebSearchOptions{
+		Enabled:           true,
+		SearchContextSize: SearchContextSizeMedium,
+		UserLocation:      &WebSearchUserLocation{City: "Paris", Country: "FR"},
+		AllowedDomains:    []string{"example.com"},
+		BlockedDomains:    []string{"spam.example"},
+		PIIFilter:         &piiFilter,
+		MaxSearches:       3,
+	}
+
+	got, err := json.Marshal(opts)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := `{"search_context_size":"medium","user_location":{"type":"approximate","approximate":{"city":"Paris","country":"FR"}},"allowed_domains":["example.com"],"blocked_domains":["spam.example"],"pii_filter":false,"max_searches":3}`
+	if string(got) != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	got, err = json.Marshal(WebSearchOptions{Enabled: true})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(got) != `{}` {
+		t.Errorf("Expected {}, got %s", got)
+	}
+}
+
+func TestWebSearchOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  WebSearchOptions
+		valid bool
+	}{
+		{"disabled", WebSearchOptions{}, true},
+		{"enabled", WebSearchOptions{Enabled: true}, true},
+		{"disabled with fields", WebSearchOptions{MaxSearches: 2}, false},
+		{"context size", WebSearchOptions{Enabled: true, SearchContextSize: SearchContextSizeHigh}, true},
+		{"unknown context size", WebSearchOptions{Enabled: true, SearchContextSize: "huge"}, false},
+		{"negative max searches", WebSearchOptions{Enabled: true, MaxSearches: -1}, false},
+		{"country code", WebSearchOptions{Enabled: true, UserLocation: &WebSearchUserLocation{Country: "us"}}, true},
+		{"country name", WebSearchOptions{Enabled: true, UserLocation: &WebSearchUserLocation{Country: "France"}}, false},
+		{"domains", WebSearchOptions{Enabled: true, AllowedDomains: []string{"go.dev"}, BlockedDomains: []string{"example.com"}}, true},
+		{"empty domain", WebSearchOptions{Enabled: true, AllowedDomains: []string{""}}, false},
+		{"domain with scheme", WebSearchOptions{Enabled: true, BlockedDomains: []string{"https://example.com"}}, false},
+		{"domain with path", WebSearchOptions{Enabled: true, AllowedDomains: []string{"example.com/news"}}, false},
+		{"allowed and blocked", WebSearchOptions{Enabled: true, AllowedDomains: []string{"example.com"}, BlockedDomains: []string{"Example.com"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("Expected valid options, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidWebSearchOptions) {
+				t.Errorf("Expected ErrInvalidWebSearchOptions, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWebSearchOptionsApplyTo(t *testing.T) {
+	var params openai.ChatCompletionNewParams
+	if err := (WebSearchOptions{}).ApplyTo(&params); err != nil {
+		t.Fatalf("ApplyTo failed: %v", err)
+	}
+	if params.ExtraFields() != nil {
+		t.Errorf("Expected no extra fields when disabled, got %v", params.ExtraFields())
+	}
+
+	params.SetExtraFields(map[string]any{"user": "u1"})
+	opts := WebSearchOptions{Enabled: true, MaxSearches: 1}
+	if err := opts.ApplyTo(&params); err != nil {
+		t.Fatalf("ApplyTo failed: %v", err)
+	}
+	extras := params.ExtraFields()
+	if extras["user"] != "u1" {
+		t.Errorf("Expected existing extra fields to be kept, got %v", extras)
+	}
+	if got, ok := extras["web_search_options"].(WebSearchOptions); !ok || got.MaxSearches != 1 {
+		t.Errorf("Expected web_search_options on params, got %v", extras["web_search_options"])
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(body), `"web_search_options":{"max_searches":1}`) {
+		t.Errorf("Expected web_search_options in request body, got %s", body)
+	}
+
+	// Web search options the caller already set are not replaced
+	if err := opts.ApplyTo(&params); err == nil {
+		t.Error("Expected an error when web_search_options is already an extra field")
+	}
+	typed := openai.ChatCompletionNewParams{
+		WebSearchOptions: openai.ChatCompletionNewParamsWebSearchOptions{SearchContextSize: "low"},
+	}
+	if err := opts.ApplyTo(&typed); err == nil {
+		t.Error("Expected an error when WebSearchOptions is set on params")
+	}
+
+	if err := (WebSearchOptions{Enabled: true, SearchContextSize: "huge"}).ApplyTo(&params); !errors.Is(err, ErrInvalidWebSearchOptions) {
+		t.Errorf("Expected ErrInvalidWebSearchOptions, got %v", err)
+	}
+}
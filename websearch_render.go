@@ -0,0 +1,263 @@
+package tinfoil
+
+import (
+	"cmp"
+	"fmt"
+	"html"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Source is a web page cited by a message, numbered in the order it is first
+// cited. Repeated citations of the same URL share one Source.
+type Source struct {
+	Number        int
+	Title         string
+	URL           string
+	PublishedDate string
+}
+
+// label returns the title of the source, or its URL if it has none.
+func (s Source) label() string {
+	if s.Title != "" {
+		return s.Title
+	}
+	return s.URL
+}
+
+// citation is a URL citation resolved to its source number and, when the
+// server provided a valid span, the rune offset its marker is placed at.
+type citation struct {
+	number  int
+	offset  int
+	hasSpan bool
+}
+
+// Sources returns the deduplicated sources cited by the message's URL
+// citations. Citations with spans are numbered in the order they appear in
+// the content, followed by citations without spans in annotation order.
+func (m *WebSearchMessage) Sources() []Source {
+	sources, _ := m.resolveCitations()
+	return sources
+}
+
+// resolveCitations numbers the message's sources and places a marker for
+// every URL citation.
+func (m *WebSearchMessage) resolveCitations() ([]Source, []citation) {
+	length := len([]rune(m.Content))
+
+	type ordered struct {
+		URLCitation
+		offset  int
+		hasSpan bool
+	}
+	var citations []ordered
+	for _, annotation := range m.Annotations {
+		if annotation.Type != "url_citation" || annotation.URLCitation.URL == "" {
+			continue
+		}
+		c := ordered{URLCitation: annotation.URLCitation}
+		if _, end, ok := annotation.URLCitation.Span(); ok && end <= length {
+			c.offset, c.hasSpan = end, true
+		}
+		citations = append(citations, c)
+	}
+	sort.SliceStable(citations, func(i, j int) bool {
+		if citations[i].hasSpan != citations[j].hasSpan {
+			return citations[i].hasSpan
+		}
+		return citations[i].hasSpan && citations[i].offset < citations[j].offset
+	})
+
+	var sources []Source
+	numbers := make(map[string]int)
+	markers := make([]citation, 0, len(citations))
+	for _, c := range citations {
+		number, seen := numbers[c.URL]
+		if !seen {
+			number = len(sources) + 1
+			numbers[c.URL] = number
+			sources = append(sources, Source{
+				Number:        number,
+				Title:         c.Title,
+				URL:           c.URL,
+				PublishedDate: c.PublishedDate,
+			})
+		} else if s := &sources[number-1]; s.Title == "" || s.PublishedDate == "" {
+			// Later citations may carry details the first one lacked
+			s.Title = cmp.Or(s.Title, c.Title)
+			s.PublishedDate = cmp.Or(s.PublishedDate, c.PublishedDate)
+		}
+		markers = append(markers, citation{number: number, offset: c.offset, hasSpan: c.hasSpan})
+	}
+	return sources, markers
+}
+
+// citationRenderer formats the parts of a rendered message.
+type citationRenderer interface {
+	text(s string) string
+	marker(source Source) string
+	body(s string) string
+	footer(sources []Source) string
+}
+
+// render interleaves the message content with citation markers, placing
+// markers without a span at the end of the content, and appends a footer
+// listing the sources.
+func (m *WebSearchMessage) render(r citationRenderer) string {
+	sources, markers := m.resolveCitations()
+	content := []rune(m.Content)
+
+	// Group markers by offset, dropping repeated markers at the same position
+	at := make(map[int][]int)
+	for _, c := range markers {
+		offset := len(content)
+		if c.hasSpan {
+			offset = c.offset
+		}
+		numbers := at[offset]
+		if !slices.Contains(numbers, c.number) {
+			at[offset] = append(numbers, c.number)
+		}
+	}
+	offsets := make([]int, 0, len(at))
+	for offset := range at {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	var b strings.Builder
+	prev := 0
+	for _, offset := range offsets {
+		b.WriteString(r.text(string(content[prev:offset])))
+		numbers := at[offset]
+		sort.Ints(numbers)
+		for _, number := range numbers {
+			b.WriteString(r.marker(sources[number-1]))
+		}
+		prev = offset
+	}
+	b.WriteString(r.text(string(content[prev:])))
+
+	rendered := r.body(b.String())
+	if len(sources) > 0 {
+		rendered += r.footer(sources)
+	}
+	return rendered
+}
+
+// RenderMarkdown renders the message as Markdown with numbered inline links
+// to each cited source and a numbered list of sources at the end.
+func (m *WebSearchMessage) RenderMarkdown() string {
+	return m.render(markdownRenderer{})
+}
+
+// RenderHTML renders the message as an HTML fragment. The content is escaped
+// and split into paragraphs, cited sources are marked with superscript links
+// and listed in an ordered list at the end.
+func (m *WebSearchMessage) RenderHTML() string {
+	return m.render(htmlRenderer{})
+}
+
+// RenderPlainText renders the message as plain text with numbered markers
+// such as "[1]" and a list of sources at the end.
+func (m *WebSearchMessage) RenderPlainText() string {
+	return m.render(plainTextRenderer{})
+}
+
+type markdownRenderer struct{}
+
+func (markdownRenderer) text(s string) string { return s }
+func (markdownRenderer) body(s string) string { return s }
+
+func (markdownRenderer) marker(source Source) string {
+	return fmt.Sprintf("[[%d]](%s)", source.Number, markdownURL(safeURL(source.URL)))
+}
+
+func (markdownRenderer) footer(sources []Source) string {
+	var b strings.Builder
+	b.WriteString("\n\n**Sources**\n\n")
+	for _, s := range sources {
+		fmt.Fprintf(&b, "%d. [%s](%s)", s.Number, markdownEscaper.Replace(s.label()), markdownURL(safeURL(s.URL)))
+		if s.PublishedDate != "" {
+			fmt.Fprintf(&b, " (%s)", s.PublishedDate)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `*`, `\*`, `_`, `\_`, "`", "\\`")
+
+// markdownURL encodes the characters that would end a Markdown link target.
+func markdownURL(url string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(url)
+}
+
+type htmlRenderer struct{}
+
+func (htmlRenderer) text(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, "\n\n", "</p>\n<p>")
+	return strings.ReplaceAll(s, "\n", "<br>\n")
+}
+
+// body opens the first paragraph and closes the last; text splits the
+// paragraphs in between.
+func (htmlRenderer) body(s string) string {
+	return "<p>" + s + "</p>"
+}
+
+func (htmlRenderer) marker(source Source) string {
+	return fmt.Sprintf(`<sup><a href="#source-%d">[%d]</a></sup>`, source.Number, source.Number)
+}
+
+func (htmlRenderer) footer(sources []Source) string {
+	var b strings.Builder
+	b.WriteString("\n<ol class=\"sources\">\n")
+	for _, s := range sources {
+		fmt.Fprintf(&b, `<li id="source-%d"><a href="%s">%s</a>`, s.Number, html.EscapeString(safeURL(s.URL)), html.EscapeString(s.label()))
+		if s.PublishedDate != "" {
+			fmt.Fprintf(&b, ` <time>%s</time>`, html.EscapeString(s.PublishedDate))
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ol>")
+	return b.String()
+}
+
+// safeURL returns url if it is an http or https URL, and "#" otherwise so
+// that cited sources cannot inject script URLs.
+func safeURL(url string) string {
+	lower := strings.ToLower(url)
+	if strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://") {
+		return url
+	}
+	return "#"
+}
+
+type plainTextRenderer struct{}
+
+func (plainTextRenderer) text(s string) string { return s }
+func (plainTextRenderer) body(s string) string { return s }
+
+func (plainTextRenderer) marker(source Source) string {
+	return fmt.Sprintf("[%d]", source.Number)
+}
+
+func (plainTextRenderer) footer(sources []Source) string {
+	var b strings.Builder
+	b.WriteString("\n\nSources:\n")
+	for _, s := range sources {
+		fmt.Fprintf(&b, "[%d] %s", s.Number, s.label())
+		if s.PublishedDate != "" {
+			fmt.Fprintf(&b, " (%s)", s.PublishedDate)
+		}
+		if s.Title != "" {
+			fmt.Fprintf(&b, " - %s", s.URL)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
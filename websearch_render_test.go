@@ -0,0 +1,139 @@
+package tinfoil
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func spanCitation(title, url, date string, start, end int) Annotation {
+	return Annotation{
+		Type: "url_citation",
+		URLCitation: URLCitation{
+			Title:         title,
+			URL:           url,
+			PublishedDate: date,
+			StartIndex:    &start,
+			EndIndex:      &end,
+		},
+	}
+}
+
+func TestURLCitationSpan(t *testing.T) {
+	var citation URLCitation
+	if err := json.Unmarshal([]byte(`{"title":"Go","url":"https://go.dev","start_index":3,"end_index":9}`), &citation); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	start, end, ok := citation.Span()
+	if !ok || start != 3 || end != 9 {
+		t.Errorf("Expected span 3-9, got %d-%d (%v)", start, end, ok)
+	}
+
+	if _, _, ok := (URLCitation{URL: "https://go.dev"}).Span(); ok {
+		t.Error("Expected no span without offsets")
+	}
+
+	start, end = 5, 2
+	if _, _, ok := (URLCitation{StartIndex: &start, EndIndex: &end}).Span(); ok {
+		t.Error("Expected no span for reversed offsets")
+	}
+}
+
+func TestWebSearchMessageSources(t *testing.T) {
+	msg := WebSearchMessage{
+		Content: "Go 1.25 is out. It is fast.",
+		Annotations: []Annotation{
+			spanCitation("", "https://b.example", "", 16, 27),
+			spanCitation("Go blog", "https://a.example", "2025-08-12", 0, 15),
+			spanCitation("B", "https://b.example", "2025-01-01", 20, 27),
+			{Type: "url_citation", URLCitation: URLCitation{Title: "C", URL: "https://c.example"}},
+		},
+	}
+
+	sources := msg.Sources()
+	if len(sources) != 3 {
+		t.Fatalf("Expected 3 sources, got %d", len(sources))
+	}
+	want := []Source{
+		{Number: 1, Title: "Go blog", URL: "https://a.example", PublishedDate: "2025-08-12"},
+		{Number: 2, Title: "B", URL: "https://b.example", PublishedDate: "2025-01-01"},
+		{Number: 3, Title: "C", URL: "https://c.example"},
+	}
+	for i := range want {
+		if sources[i] != want[i] {
+			t.Errorf("Source %d: expected %+v, got %+v", i, want[i], sources[i])
+		}
+	}
+}
+
+func TestWebSearchMessageRenderPlainText(t *testing.T) {
+	msg := WebSearchMessage{
+		Content: "Héllo wörld. Second.",
+		Annotations: []Annotation{
+			spanCitation("First", "https://a.example", "2025-08-12", 0, 12),
+			spanCitation("Second", "https://b.example", "", 13, 20),
+			spanCitation("First again", "https://a.example", "", 13, 20),
+		},
+	}
+
+	want := "Héllo wörld.[1] Second.[1][2]\n\nSources:\n[1] First (2025-08-12) - https://a.example\n[2] Second - https://b.example\n"
+	if got := msg.RenderPlainText(); got != want {
+		t.Errorf("Expected:\n%q\ngot:\n%q", want, got)
+	}
+}
+
+func TestWebSearchMessageRenderWithoutSpans(t *testing.T) {
+	msg := WebSearchMessage{
+		Content: "Answer.",
+		Annotations: []Annotation{
+			{Type: "url_citation", URLCitation: URLCitation{URL: "https://a.example"}},
+			{Type: "url_citation", URLCitation: URLCitation{URL: "https://a.example"}},
+			{Type: "url_citation", URLCitation: URLCitation{Title: "B", URL: "https://b.example"}},
+		},
+	}
+
+	want := "Answer.[1][2]\n\nSources:\n[1] https://a.example\n[2] B - https://b.example\n"
+	if got := msg.RenderPlainText(); got != want {
+		t.Errorf("Expected:\n%q\ngot:\n%q", want, got)
+	}
+
+	if got := (&WebSearchMessage{Content: "No citations."}).RenderPlainText(); got != "No citations." {
+		t.Errorf("Expected content unchanged, got %q", got)
+	}
+}
+
+func TestWebSearchMessageRenderMarkdown(t *testing.T) {
+	msg := WebSearchMessage{
+		Content: "Go is fun.",
+		Annotations: []Annotation{
+			spanCitation("The [Go] blog", "https://go.dev/blog (new)", "2025-08-12", 0, 10),
+		},
+	}
+
+	want := "Go is fun.[[1]](https://go.dev/blog%20%28new%29)\n\n**Sources**\n\n1. [The \\[Go\\] blog](https://go.dev/blog%20%28new%29) (2025-08-12)\n"
+	if got := msg.RenderMarkdown(); got != want {
+		t.Errorf("Expected:\n%q\ngot:\n%q", want, got)
+	}
+}
+
+func TestWebSearchMessageRenderHTML(t *testing.T) {
+	msg := WebSearchMessage{
+		Content: "<b>Go</b> & more.\n\nNext line.",
+		Annotations: []Annotation{
+			spanCitation("Go & co", "https://go.dev/?a=1&b=2", "2025-08-12", 0, 17),
+			{Type: "url_citation", URLCitation: URLCitation{Title: "Bad", URL: "javascript:alert(1)"}},
+		},
+	}
+
+	got := msg.RenderHTML()
+	for _, want := range []string{
+		`<p>&lt;b&gt;Go&lt;/b&gt; &amp; more.<sup><a href="#source-1">[1]</a></sup></p>`,
+		"<p>Next line.<sup><a href=\"#source-2\">[2]</a></sup></p>",
+		`<li id="source-1"><a href="https://go.dev/?a=1&amp;b=2">Go &amp; co</a> <time>2025-08-12</time></li>`,
+		`<li id="source-2"><a href="#">Bad</a></li>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected HTML to contain %q, got:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package tinfoil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/openai/openai-go/v3/option"
+	"github.com/openai/openai-go/v3/packages/param"
+	"github.com/openai/openai-go/v3/responses"
+)
+
+// ResponsesTool validates the options and returns them as a web search tool
+// for a Responses API request. Options the openai-go tool type does not model
+// are sent as extra fields.
+func (o WebSearchOptions) ResponsesTool() (responses.ToolUnionParam, error) {
+	if err := o.Validate(); err != nil {
+		return responses.ToolUnionParam{}, err
+	}
+	if !o.Enabled {
+		return responses.ToolUnionParam{}, invalidWebSearchOptions("web search is not enabled")
+	}
+
+	tool := responses.WebSearchToolParam{
+		Type:              responses.WebSearchToolTypeWebSearch,
+		SearchContextSize: responses.WebSearchToolSearchContextSize(o.SearchContextSize),
+	}
+	if l := o.UserLocation; l != nil {
+		tool.UserLocation = responses.WebSearchToolUserLocationParam{Type: "approximate"}
+		if l.City != "" {
+			tool.UserLocation.City = param.NewOpt(l.City)
+		}
+		if l.Region != "" {
+			tool.UserLocation.Region = param.NewOpt(l.Region)
+		}
+		if l.Country != "" {
+			tool.UserLocation.Country = param.NewOpt(l.Country)
+		}
+		if l.Timezone != "" {
+			tool.UserLocation.Timezone = param.NewOpt(l.Timezone)
+		}
+	}
+	tool.Filters.AllowedDomains = o.AllowedDomains
+	if len(o.BlockedDomains) > 0 {
+		tool.Filters.SetExtraFields(map[string]any{"blocked_domains": o.BlockedDomains})
+	}
+
+	extra := make(map[string]any)
+	if o.PIIFilter != nil {
+		extra["pii_filter"] = *o.PIIFilter
+	}
+	if o.MaxSearches > 0 {
+		extra["max_searches"] = o.MaxSearches
+	}
+	if len(extra) > 0 {
+		tool.SetExtraFields(extra)
+	}
+
+	return responses.ToolUnionParam{OfWebSearch: &tool}, nil
+}
+
+// WithResponseStreamOptions returns a request option that configures the
+// stream returned by NewResponseWebSearchStreaming.
+func WithResponseStreamOptions(opts ResponseStreamOptions) option.RequestOption {
+	return newStreamOption(opts)
+}
+
+// NewResponseWebSearchStreaming sends a streaming Responses API request with
+// the web search tool configured by opts added to params.Tools, and returns a
+// stream of response events configured by WithResponseStreamOptions among
+// reqOpts. Non-2xx responses are returned as *openai.Error. The caller must
+// close the returned stream.
+func (c *Client) NewResponseWebSearchStreaming(ctx context.Context, params responses.ResponseNewParams, opts WebSearchOptions, reqOpts ...option.RequestOption) (*ResponseStream, error) {
+	tool, err := opts.ResponsesTool()
+	if err != nil {
+		return nil, err
+	}
+	params.Tools = append(append([]responses.ToolUnionParam(nil), params.Tools...), tool)
+	streamOpts, reqOpts := takeStreamOptions[ResponseStreamOptions](reqOpts)
+
+	var resp *http.Response
+	reqOpts = append([]option.RequestOption{option.WithJSONSet("stream", true)}, reqOpts...)
+	if err := c.Post(ctx, "responses", params, &resp, reqOpts...); err != nil {
+		return nil, err
+	}
+	return NewResponseStreamWithContext(ctx, resp.Body, streamOpts), nil
+}
+
+// Responses API stream event types relevant to web search.
+const (
+	ResponseEventCreated              = "response.created"
+	ResponseEventInProgress           = "response.in_progress"
+	ResponseEventCompleted            = "response.completed"
+	ResponseEventFailed               = "response.failed"
+	ResponseEventIncomplete           = "response.incomplete"
+	ResponseEventOutputItemAdded      = "response.output_item.added"
+	ResponseEventOutputItemDone       = "response.output_item.done"
+	ResponseEventWebSearchInProgress  = "response.web_search_call.in_progress"
+	ResponseEventWebSearchSearching   = "response.web_search_call.searching"
+	ResponseEventWebSearchCompleted   = "response.web_search_call.completed"
+	ResponseEventOutputTextDelta      = "response.output_text.delta"
+	ResponseEventOutputTextDone       = "response.output_text.done"
+	ResponseEventOutputTextAnnotation = "response.output_text.annotation.added"
+	ResponseEventError                = "error"
+)
+
+// ResponseStreamEvent is a single event of a streaming Responses API request.
+// Which fields are set depends on Type.
+type ResponseStreamEvent struct {
+	Type           string `json:"type"`
+	SequenceNumber int64  `json:"sequence_number"`
+
+	// Output item and content part the event refers to
+	ItemID       string `json:"item_id,omitempty"`
+	OutputIndex  int    `json:"output_index"`
+	ContentIndex int    `json:"content_index"`
+
+	Delta           string              `json:"delta,omitempty"` // response.output_text.delta
+	Text            string              `json:"text,omitempty"`  // response.output_text.done
+	Annotation      *ResponseAnnotation `json:"annotation,omitempty"`
+	AnnotationIndex int                 `json:"annotation_index"`
+
+	Item     *ResponseOutputItem `json:"item,omitempty"`     // response.output_item.*
+	Response *ResponseInfo       `json:"response,omitempty"` // response.created, .completed, ...
+
+	// Error details of an "error" event
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// Raw is the event's JSON as received.
+	Raw json.RawMessage `json:"-"`
+}
+
+// ResponseAnnotation is an annotation of output text. URL citations share
+// the URLCitation model, with spans relative to the content part's text.
+type ResponseAnnotation struct {
+	Type string `json:"type"` // "url_citation"
+	URLCitation
+}
+
+// ToAnnotation converts the annotation to the chat completions form.
+func (a ResponseAnnotation) ToAnnotation() Annotation {
+	return Annotation{Type: a.Type, URLCitation: a.URLCitation}
+}
+
+// ResponseOutputItem is an output item of a response. Only the fields used
+// by web search calls and messages are modeled.
+type ResponseOutputItem struct {
+	Type   string           `json:"type"` // "web_search_call", "message", ...
+	ID     string           `json:"id"`
+	Status string           `json:"status,omitempty"`
+	Role   string           `json:"role,omitempty"`
+	Action *WebSearchAction `json:"action,omitempty"`
+}
+
+// ResponseInfo describes the response in lifecycle events.
+type ResponseInfo struct {
+	ID     string         `json:"id"`
+	Status string         `json:"status"`
+	Model  string         `json:"model,omitempty"`
+	Error  *ResponseError `json:"error,omitempty"`
+	Usage  *ResponseUsage `json:"usage,omitempty"`
+}
+
+// ResponseUsage reports the tokens used by a response.
+type ResponseUsage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+	TotalTokens  int64 `json:"total_tokens"`
+}
+
+// ResponseError is a Responses API error, reported by ResponseStream.Err for
+// "error" events and carried by failed responses.
+type ResponseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("response error: %s", e.Message)
+	}
+	return fmt.Sprintf("response error %s: %s", e.Code, e.Message)
+}
+
+// ToWebSearchCall converts web search progress events and web search output
+// items to a WebSearchCall. Returns nil for other events. The Responses API
+// "searching" status is reported as in progress.
+func (e *ResponseStreamEvent) ToWebSearchCall() *WebSearchCall {
+	switch e.Type {
+	case ResponseEventWebSearchInProgress, ResponseEventWebSearchSearching:
+		return &WebSearchCall{Type: "web_search_call", ID: e.ItemID, Status: WebSearchStatusInProgress}
+	case ResponseEventWebSearchCompleted:
+		return &WebSearchCall{Type: "web_search_call", ID: e.ItemID, Status: WebSearchStatusCompleted}
+	case ResponseEventOutputItemAdded, ResponseEventOutputItemDone:
+		if e.Item == nil || e.Item.Type != "web_search_call" {
+			return nil
+		}
+		status := WebSearchStatus(e.Item.Status)
+		if e.Item.Status == "searching" {
+			status = WebSearchStatusInProgress
+		}
+		return &WebSearchCall{Type: "web_search_call", ID: e.Item.ID, Status: status, Action: e.Item.Action}
+	}
+	return nil
+}
+
+// ResponseStream parses the events of a streaming Responses API request.
+type ResponseStream struct {
+	events  *eventStream
+	current *ResponseStreamEvent
+	failed  error // reported after the response.failed event is returned
+}
+
+// ResponseStreamOptions configures a ResponseStream.
+type ResponseStreamOptions struct {
+	// MaxEventSize limits the size in bytes of a single streamed event.
+	// Larger events fail the stream with ErrEventTooLarge. Zero selects
+	// DefaultMaxEventSize.
+	MaxEventSize int
+
+	// IdleTimeout aborts the stream when Next waits longer than this for an
+	// event. Zero disables the timeout.
+	IdleTimeout time.Duration
+}
+
+// NewResponseStream creates a ResponseStream from a streaming HTTP response body.
+func NewResponseStream(body io.ReadCloser) *ResponseStream {
+	return NewResponseStreamWithOptions(body, ResponseStreamOptions{})
+}
+
+// NewResponseStreamWithOptions creates a ResponseStream from a streaming HTTP
+// response body with the given options.
+func NewResponseStreamWithOptions(body io.ReadCloser, opts ResponseStreamOptions) *ResponseStream {
+	return NewResponseStreamWithContext(context.Background(), body, opts)
+}
+
+// NewResponseStreamWithContext creates a ResponseStream that is aborted when
+// ctx is done or opts.IdleTimeout elapses without an event. Aborting closes
+// body, and Err then reports a *StreamAbortedError.
+func NewResponseStreamWithContext(ctx context.Context, body io.ReadCloser, opts ResponseStreamOptions) *ResponseStream {
+	return &ResponseStream{
+		events: newEventStream(ctx, body, opts.MaxEventSize, opts.IdleTimeout),
+	}
+}
+
+// Next advances to the next event. It returns false when the stream ends or
+// an error occurs. An "error" event ends the stream with a *ResponseError, as
+// does a response.failed event after it has been returned.
+func (s *ResponseStream) Next() bool {
+	if s.events.done() {
+		return false
+	}
+	if s.failed != nil {
+		s.events.finish(s.failed)
+		return false
+	}
+
+	for {
+		data, err := s.events.next()
+		if err == io.EOF {
+			s.events.finish(nil)
+			return false
+		}
+		if err != nil {
+			s.events.finish(err)
+			return false
+		}
+		if data == "[DONE]" {
+			continue
+		}
+
+		var event ResponseStreamEvent
+		raw := []byte(data)
+		if err := json.Unmarshal(raw, &event); err != nil {
+			s.events.finish(fmt.Errorf("failed to parse response event: %w", err))
+			return false
+		}
+		event.Raw = raw
+
+		switch event.Type {
+		case ResponseEventError:
+			s.events.finish(&ResponseError{Code: event.Code, Message: event.Message})
+			return false
+		case ResponseEventFailed:
+			s.failed = &ResponseError{Message: "response failed"}
+			if event.Response != nil && event.Response.Error != nil {
+				s.failed = event.Response.Error
+			}
+		}
+
+		s.current = &event
+		return true
+	}
+}
+
+// Current returns the current event. Must be called after Next returns true.
+func (s *ResponseStream) Current() *ResponseStreamEvent {
+	return s.current
+}
+
+// Err returns any error that occurred during streaming.
+func (s *ResponseStream) Err() error {
+	return s.events.err
+}
+
+// Close closes the underlying reader.
+func (s *ResponseStream) Close() error {
+	return s.events.close()
+}
+
+// ResponseAccumulator assembles the events of a ResponseStream into a
+// WebSearchMessage, so responses render like chat completions. The text of
+// all output text parts is concatenated, separated by blank lines, and the
+// spans of URL citations are shifted to index into the combined content.
+type ResponseAccumulator struct {
+	// The up-to-date accumulation of the output text and its citations
+	WebSearchMessage
+
+	// Searches lists every web search call in the order it was first seen,
+	// each with its most recent status.
+	Searches []WebSearchCall
+
+	// ID, Model, Status and Usage are taken from the response lifecycle events.
+	ID     string
+	Model  string
+	Status string
+	Usage  *ResponseUsage
+	Error  *ResponseError
+
+	searches   WebSearchAccumulator
+	parts      map[[2]int]int // (output index, content index) -> rune offset in Content
+	contentLen int
+}
+
+// AddEvent incorporates a stream event into the accumulation. Events must be
+// added in order. Returns false if the event could not be accumulated.
+func (acc *ResponseAccumulator) AddEvent(event *ResponseStreamEvent) bool {
+	// Only search events reach the inner accumulator, so forget what the
+	// previous event finished here
+	acc.searches.clearJustFinished()
+	if event == nil {
+		return false
+	}
+
+	if call := event.ToWebSearchCall(); call != nil {
+		if !acc.searches.AddEvent(&WebSearchStreamEvent{
+			Type:   call.Type,
+			ID:     call.ID,
+			Status: call.Status,
+			Action: call.Action,
+		}) {
+			return false
+		}
+		acc.Searches = acc.searches.Searches
+		return true
+	}
+
+	switch event.Type {
+	case ResponseEventCreated, ResponseEventInProgress, ResponseEventCompleted, ResponseEventFailed, ResponseEventIncomplete:
+		if r := event.Response; r != nil {
+			acc.ID = r.ID
+			acc.Model = r.Model
+			acc.Status = r.Status
+			if r.Usage != nil {
+				acc.Usage = r.Usage
+			}
+			if r.Error != nil {
+				acc.Error = r.Error
+			}
+		}
+
+	case ResponseEventOutputItemAdded:
+		if event.Item != nil && event.Item.Role != "" {
+			acc.Role = event.Item.Role
+		}
+
+	case ResponseEventOutputTextDelta:
+		acc.partOffset(event)
+		acc.Content += event.Delta
+		acc.contentLen += len([]rune(event.Delta))
+
+	case ResponseEventOutputTextAnnotation:
+		if event.Annotation == nil {
+			return false
+		}
+		annotation := event.Annotation.ToAnnotation()
+		if start, end, ok := annotation.URLCitation.Span(); ok {
+			offset := acc.partOffset(event)
+			start, end = start+offset, end+offset
+			annotation.URLCitation.StartIndex = &start
+			annotation.URLCitation.EndIndex = &end
+		}
+		acc.Annotations = append(acc.Annotations, annotation)
+	}
+	return true
+}
+
+// partOffset returns the offset of the event's content part in Content,
+// starting a new part if needed. Parts are assumed to stream one after
+// another, as the API does.
+func (acc *ResponseAccumulator) partOffset(event *ResponseStreamEvent) int {
+	if acc.parts == nil {
+		acc.parts = make(map[[2]int]int)
+	}
+
+	key := [2]int{event.OutputIndex, event.ContentIndex}
+	if offset, ok := acc.parts[key]; ok {
+		return offset
+	}
+	if acc.contentLen > 0 {
+		acc.Content += "\n\n"
+		acc.contentLen += 2
+	}
+	acc.parts[key] = acc.contentLen
+	return acc.contentLen
+}
+
+// JustFinishedSearch retrieves a web search call when the last added event
+// moved it to a terminal status.
+func (acc *ResponseAccumulator) JustFinishedSearch() (search WebSearchCall, ok bool) {
+	return acc.searches.JustFinishedSearch()
+}
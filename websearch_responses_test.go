@@ -0,0 +1,284 @@
+package tinfoil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponsesTool(t *testing.T) {
+	pii := false
+	tool, err := WebSearchOptions{
+		Enabled:           true,
+		SearchContextSize: SearchContextSizeLow,
+		UserLocation:      &WebSearchUserLocation{City: "Paris", Country: "FR"},
+		AllowedDomains:    []string{"example.com"},
+		BlockedDomains:    []string{"spam.example.org"},
+		PIIFilter:         &pii,
+		MaxSearches:       3,
+	}.ResponsesTool()
+	if err != nil {
+		t.Fatalf("ResponsesTool: %v", err)
+	}
+
+	data, err := json.Marshal(tool)
+	if err != nil {
+		t.Fatalf("marshal tool: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal tool: %v", err)
+	}
+
+	if got["type"] != "web_search" || got["search_context_size"] != "low" {
+		t.Errorf("unexpected tool: %s", data)
+	}
+	if got["pii_filter"] != false || got["max_searches"] != float64(3) {
+		t.Errorf("extra fields missing: %s", data)
+	}
+	location, _ := got["user_location"].(map[string]any)
+	if location["type"] != "approximate" || location["city"] != "Paris" || location["country"] != "FR" {
+		t.Errorf("unexpected user location: %v", location)
+	}
+	if _, ok := location["region"]; ok {
+		t.Errorf("unset region was sent: %v", location)
+	}
+	filters, _ := got["filters"].(map[string]any)
+	allowed, _ := filters["allowed_domains"].([]any)
+	blocked, _ := filters["blocked_domains"].([]any)
+	if len(allowed) != 1 || allowed[0] != "example.com" || len(blocked) != 1 || blocked[0] != "spam.example.org" {
+		t.Errorf("unexpected filters: %v", filters)
+	}
+}
+
+func TestResponsesToolInvalid(t *testing.T) {
+	if _, err := (WebSearchOptions{}).ResponsesTool(); !errors.Is(err, ErrInvalidWebSearchOptions) {
+		t.Errorf("expected error for disabled options, got %v", err)
+	}
+	if _, err := (WebSearchOptions{Enabled: true, MaxSearches: -1}).ResponsesTool(); !errors.Is(err, ErrInvalidWebSearchOptions) {
+		t.Errorf("expected validation error, got %v", err)
+	}
+}
+
+const responseStreamBody = `event: response.created
+data: {"type":"response.created","sequence_number":0,"response":{"id":"resp_1","status":"in_progress","model":"gpt-oss-120b"}}
+
+data: {"type":"response.output_item.added","sequence_number":1,"output_index":0,"item":{"type":"web_search_call","id":"ws_1","status":"in_progress"}}
+
+data: {"type":"response.web_search_call.searching","sequence_number":2,"output_index":0,"item_id":"ws_1"}
+
+data: {"type":"response.web_search_call.completed","sequence_number":3,"output_index":0,"item_id":"ws_1"}
+
+data: {"type":"response.output_item.done","sequence_number":4,"output_index":0,"item":{"type":"web_search_call","id":"ws_1","status":"completed","action":{"type":"search","query":"weather paris"}}}
+
+data: {"type":"response.output_item.added","sequence_number":5,"output_index":1,"item":{"type":"message","id":"msg_1","status":"in_progress","role":"assistant"}}
+
+data: {"type":"response.output_text.delta","sequence_number":6,"item_id":"msg_1","output_index":1,"content_index":0,"delta":"It is "}
+
+data: {"type":"response.output_text.delta","sequence_number":7,"item_id":"msg_1","output_index":1,"content_index":0,"delta":"sunny."}
+
+data: {"type":"response.output_text.annotation.added","sequence_number":8,"item_id":"msg_1","output_index":1,"content_index":0,"annotation_index":0,"annotation":{"type":"url_citation","title":"Weather","url":"https://weather.example.com","start_index":6,"end_index":12}}
+
+data: {"type":"response.output_text.delta","sequence_number":9,"item_id":"msg_1","output_index":1,"content_index":1,"delta":"Enjoy."}
+
+data: {"type":"response.output_text.annotation.added","sequence_number":10,"item_id":"msg_1","output_index":1,"content_index":1,"annotation_index":0,"annotation":{"type":"url_citation","title":"Tips","url":"https://tips.example.com","start_index":0,"end_index":5}}
+
+data: {"type":"response.completed","sequence_number":11,"response":{"id":"resp_1","status":"completed","model":"gpt-oss-120b","usage":{"input_tokens":10,"output_tokens":5,"total_tokens":15}}}
+
+`
+
+func TestResponseStream(t *testing.T) {
+	stream := NewResponseStream(io.NopCloser(strings.NewReader(responseStreamBody)))
+	defer stream.Close()
+
+	var acc ResponseAccumulator
+	var types []string
+	var finished []WebSearchCall
+	for stream.Next() {
+		event := stream.Current()
+		if len(event.Raw) == 0 {
+			t.Errorf("event %s has no raw JSON", event.Type)
+		}
+		types = append(types, event.Type)
+		if !acc.AddEvent(event) {
+			t.Errorf("event %s was not accumulated", event.Type)
+		}
+		if search, ok := acc.JustFinishedSearch(); ok {
+			finished = append(finished, search)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+
+	if len(types) != 12 || types[0] != ResponseEventCreated || types[11] != ResponseEventCompleted {
+		t.Errorf("unexpected event types: %v", types)
+	}
+	if acc.ID != "resp_1" || acc.Model != "gpt-oss-120b" || acc.Status != "completed" {
+		t.Errorf("unexpected response info: %q %q %q", acc.ID, acc.Model, acc.Status)
+	}
+	if acc.Usage == nil || acc.Usage.TotalTokens != 15 {
+		t.Errorf("unexpected usage: %+v", acc.Usage)
+	}
+	if acc.Role != "assistant" {
+		t.Errorf("expected assistant role, got %q", acc.Role)
+	}
+
+	if len(acc.Searches) != 1 {
+		t.Fatalf("expected 1 search, got %d", len(acc.Searches))
+	}
+	search := acc.Searches[0]
+	if search.Status != WebSearchStatusCompleted || search.Action == nil || search.Action.Query != "weather paris" {
+		t.Errorf("unexpected search: %+v", search)
+	}
+	if len(finished) != 1 || finished[0].ID != "ws_1" {
+		t.Errorf("expected ws_1 to finish once, got %+v", finished)
+	}
+
+	if acc.Content != "It is sunny.\n\nEnjoy." {
+		t.Errorf("unexpected content: %q", acc.Content)
+	}
+	if len(acc.Annotations) != 2 {
+		t.Fatalf("expected 2 annotations, got %d", len(acc.Annotations))
+	}
+	for i, want := range []string{"sunny.", "Enjoy"} {
+		start, end, ok := acc.Annotations[i].URLCitation.Span()
+		if !ok {
+			t.Fatalf("annotation %d has no span", i)
+		}
+		if got := string([]rune(acc.Content)[start:end]); got != want {
+			t.Errorf("annotation %d spans %q, want %q", i, got, want)
+		}
+	}
+
+	sources := acc.Sources()
+	if len(sources) != 2 || sources[0].URL != "https://weather.example.com" {
+		t.Errorf("unexpected sources: %+v", sources)
+	}
+}
+
+func TestResponseStreamErrorEvent(t *testing.T) {
+	body := `data: {"type":"response.output_text.delta","delta":"Hi"}
+
+data: {"type":"error","code":"rate_limit_exceeded","message":"slow down"}
+
+`
+	stream := NewResponseStream(io.NopCloser(strings.NewReader(body)))
+	defer stream.Close()
+
+	var events int
+	for stream.Next() {
+		events++
+	}
+	if events != 1 {
+		t.Errorf("expected 1 event before the error, got %d", events)
+	}
+	var respErr *ResponseError
+	if !errors.As(stream.Err(), &respErr) || respErr.Code != "rate_limit_exceeded" {
+		t.Errorf("expected ResponseError, got %v", stream.Err())
+	}
+}
+
+func TestResponseStreamFailed(t *testing.T) {
+	body := `data: {"type":"response.failed","response":{"id":"resp_1","status":"failed","error":{"code":"server_error","message":"search backend unavailable"}}}
+
+`
+	stream := NewResponseStream(io.NopCloser(strings.NewReader(body)))
+	defer stream.Close()
+
+	var acc ResponseAccumulator
+	for stream.Next() {
+		acc.AddEvent(stream.Current())
+	}
+	if acc.Status != "failed" {
+		t.Errorf("expected the failed event to reach the accumulator, got status %q", acc.Status)
+	}
+	var respErr *ResponseError
+	if !errors.As(stream.Err(), &respErr) || respErr.Code != "server_error" {
+		t.Errorf("expected ResponseError from the failed response, got %v", stream.Err())
+	}
+}
+
+func TestResponseStreamIdleTimeout(t *testing.T) {
+	reader, writer := io.Pipe()
+	defer writer.Close()
+
+	stream := NewResponseStreamWithOptions(reader, ResponseStreamOptions{IdleTimeout: 20 * time.Millisecond})
+	defer stream.Close()
+
+	if stream.Next() {
+		t.Fatal("expected stream to stop after the idle timeout")
+	}
+	if !errors.Is(stream.Err(), ErrStreamIdle) {
+		t.Errorf("expected ErrStreamIdle, got %v", stream.Err())
+	}
+}
+
+func TestResponseStreamAborted(t *testing.T) {
+	reader, writer := io.Pipe()
+	defer writer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := NewResponseStreamWithContext(ctx, reader, ResponseStreamOptions{})
+	defer stream.Close()
+
+	go func() {
+		io.WriteString(writer, `data: {"type":"response.created","response":{"id":"resp_1"}}`+"\n\n")
+	}()
+	if !stream.Next() {
+		t.Fatalf("expected first event, got %v", stream.Err())
+	}
+
+	cancel()
+	if stream.Next() {
+		t.Fatal("expected stream to stop after cancel")
+	}
+	var aborted *StreamAbortedError
+	if !errors.As(stream.Err(), &aborted) || !errors.Is(stream.Err(), context.Canceled) {
+		t.Errorf("expected StreamAbortedError wrapping context.Canceled, got %v", stream.Err())
+	}
+}
+
+func TestResponseEventToWebSearchCall(t *testing.T) {
+	tests := []struct {
+		event ResponseStreamEvent
+		want  *WebSearchCall
+	}{
+		{ResponseStreamEvent{Type: ResponseEventWebSearchSearching, ItemID: "ws_1"}, &WebSearchCall{Type: "web_search_call", ID: "ws_1", Status: WebSearchStatusInProgress}},
+		{ResponseStreamEvent{Type: ResponseEventWebSearchCompleted, ItemID: "ws_1"}, &WebSearchCall{Type: "web_search_call", ID: "ws_1", Status: WebSearchStatusCompleted}},
+		{ResponseStreamEvent{Type: ResponseEventOutputItemDone, Item: &ResponseOutputItem{Type: "web_search_call", ID: "ws_2", Status: "failed"}}, &WebSearchCall{Type: "web_search_call", ID: "ws_2", Status: WebSearchStatusFailed}},
+		{ResponseStreamEvent{Type: ResponseEventOutputItemAdded, Item: &ResponseOutputItem{Type: "message", ID: "msg_1"}}, nil},
+		{ResponseStreamEvent{Type: ResponseEventOutputTextDelta, Delta: "Hi"}, nil},
+	}
+
+	for _, tt := range tests {
+		got := tt.event.ToWebSearchCall()
+		if (got == nil) != (tt.want == nil) {
+			t.Errorf("%s: got %+v, want %+v", tt.event.Type, got, tt.want)
+			continue
+		}
+		if got != nil && (got.ID != tt.want.ID || got.Status != tt.want.Status || got.Type != tt.want.Type) {
+			t.Errorf("%s: got %+v, want %+v", tt.event.Type, got, tt.want)
+		}
+	}
+}
+
+func TestResponseAccumulatorJustFinishedSearch(t *testing.T) {
+	var acc ResponseAccumulator
+	events := []*ResponseStreamEvent{
+		{Type: ResponseEventWebSearchCompleted, ItemID: "ws_1"},
+		{Type: ResponseEventOutputTextDelta, Delta: "It is"},
+		{Type: ResponseEventOutputTextDelta, Delta: " sunny."},
+	}
+
+	for i, event := range events {
+		acc.AddEvent(event)
+		if _, ok := acc.JustFinishedSearch(); ok != (i == 0) {
+			t.Errorf("event %d: JustFinishedSearch ok = %v", i, ok)
+		}
+	}
+}
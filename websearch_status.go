@@ -0,0 +1,136 @@
+package tinfoil
+
+import "fmt"
+
+// WebSearchStatus is the status of a web search call.
+type WebSearchStatus string
+
+// Web search call statuses. A search starts in progress and then moves to
+// exactly one terminal status.
+const (
+	WebSearchStatusInProgress WebSearchStatus = "in_progress"
+	WebSearchStatusCompleted  WebSearchStatus = "completed"
+	WebSearchStatusFailed     WebSearchStatus = "failed"
+	WebSearchStatusBlocked    WebSearchStatus = "blocked"
+)
+
+// IsTerminal reports whether the status is final: completed, failed or blocked.
+func (s WebSearchStatus) IsTerminal() bool {
+	return s == WebSearchStatusCompleted || s == WebSearchStatusFailed || s == WebSearchStatusBlocked
+}
+
+// IsBlocked reports whether the search was blocked, e.g. because its query
+// contained personally identifiable information.
+func (s WebSearchStatus) IsBlocked() bool {
+	return s == WebSearchStatusBlocked
+}
+
+// isKnown reports whether the status is one of the documented statuses.
+func (s WebSearchStatus) isKnown() bool {
+	return s == WebSearchStatusInProgress || s.IsTerminal()
+}
+
+// WebSearchProtocolError describes a web search call event that does not
+// follow the expected status progression.
+type WebSearchProtocolError struct {
+	ID   string          // Search ID, empty if the event had none
+	From WebSearchStatus // Status before the event, empty if the search was not seen yet
+	To   WebSearchStatus // Status carried by the event, empty if the stream ended
+}
+
+func (e *WebSearchProtocolError) Error() string {
+	switch {
+	case e.ID == "":
+		return fmt.Sprintf("web search call with status %q has no id", e.To)
+	case e.To == "":
+		return fmt.Sprintf("web search %q: stream ended while search was %s", e.ID, e.From)
+	case e.From == "":
+		return fmt.Sprintf("web search %q: unexpected initial status %q", e.ID, e.To)
+	default:
+		return fmt.Sprintf("web search %q: invalid status transition from %s to %q", e.ID, e.From, e.To)
+	}
+}
+
+// WebSearchTracker validates the status transitions of web search calls per
+// search ID. A search must first appear in progress, or blocked if it was
+// rejected before running, and may then move to a single terminal status.
+// Repeated in-progress events are allowed.
+//
+// Set a tracker on WebSearchStreamOptions to validate a stream as it is read.
+// The zero value is ready to use.
+type WebSearchTracker struct {
+	// Strict fails the stream with the first violation. Otherwise violations
+	// are only recorded.
+	Strict bool
+
+	statuses   map[string]WebSearchStatus
+	order      []string
+	violations []error
+}
+
+// Observe records a web search call event, returning a *WebSearchProtocolError
+// if it violates the expected progression. Violations are also recorded.
+func (t *WebSearchTracker) Observe(call *WebSearchCall) error {
+	if call.ID == "" {
+		return t.violation(&WebSearchProtocolError{To: call.Status})
+	}
+
+	if t.statuses == nil {
+		t.statuses = make(map[string]WebSearchStatus)
+	}
+	from, seen := t.statuses[call.ID]
+
+	var valid bool
+	switch {
+	case !call.Status.isKnown():
+		valid = false
+	case !seen:
+		valid = call.Status == WebSearchStatusInProgress || call.Status == WebSearchStatusBlocked
+	default:
+		valid = from == WebSearchStatusInProgress
+	}
+
+	// Keep the first terminal status so a later event cannot overwrite it
+	if !from.IsTerminal() && call.Status.isKnown() {
+		if !seen {
+			t.order = append(t.order, call.ID)
+		}
+		t.statuses[call.ID] = call.Status
+	}
+
+	if !valid {
+		return t.violation(&WebSearchProtocolError{ID: call.ID, From: from, To: call.Status})
+	}
+	return nil
+}
+
+// Finish reports searches still in progress when the stream ended, returning
+// the first violation. Violations are also recorded.
+func (t *WebSearchTracker) Finish() error {
+	var first error
+	for _, id := range t.order {
+		if status := t.statuses[id]; status == WebSearchStatusInProgress {
+			err := t.violation(&WebSearchProtocolError{ID: id, From: status})
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Status returns the most recent valid status of the search with the given ID.
+func (t *WebSearchTracker) Status(id string) (WebSearchStatus, bool) {
+	status, ok := t.statuses[id]
+	return status, ok
+}
+
+// Violations returns every violation recorded so far, in order.
+func (t *WebSearchTracker) Violations() []error {
+	return t.violations
+}
+
+func (t *WebSearchTracker) violation(err error) error {
+	t.violations = append(t.violations, err)
+	return err
+}
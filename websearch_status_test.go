@@ -0,0 +1,203 @@
+package tinfoil
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWebSearchStatusPredicates(t *testing.T) {
+	tests := []struct {
+		status   WebSearchStatus
+		terminal bool
+		blocked  bool
+	}{
+		{WebSearchStatusInProgress, false, false},
+		{WebSearchStatusCompleted, true, false},
+		{WebSearchStatusFailed, true, false},
+		{WebSearchStatusBlocked, true, true},
+		{"unknown", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.status.IsTerminal(); got != tt.terminal {
+			t.Errorf("%q.IsTerminal() = %v, want %v", tt.status, got, tt.terminal)
+		}
+		if got := tt.status.IsBlocked(); got != tt.blocked {
+			t.Errorf("%q.IsBlocked() = %v, want %v", tt.status, got, tt.blocked)
+		}
+	}
+}
+
+func TestWebSearchTracker(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []WebSearchStatus
+		invalid  int // index of the first invalid event, -1 if all are valid
+	}{
+		{"completed", []WebSearchStatus{WebSearchStatusInProgress, WebSearchStatusCompleted}, -1},
+		{"failed", []WebSearchStatus{WebSearchStatusInProgress, WebSearchStatusInProgress, WebSearchStatusFailed}, -1},
+		{"blocked before running", []WebSearchStatus{WebSearchStatusBlocked}, -1},
+		{"blocked while running", []WebSearchStatus{WebSearchStatusInProgress, WebSearchStatusBlocked}, -1},
+		{"completed without in progress", []WebSearchStatus{WebSearchStatusCompleted}, 0},
+		{"failed without in progress", []WebSearchStatus{WebSearchStatusFailed}, 0},
+		{"update after terminal", []WebSearchStatus{WebSearchStatusInProgress, WebSearchStatusCompleted, WebSearchStatusInProgress}, 2},
+		{"second terminal", []WebSearchStatus{WebSearchStatusInProgress, WebSearchStatusCompleted, WebSearchStatusFailed}, 2},
+		{"unknown status", []WebSearchStatus{WebSearchStatusInProgress, "done"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tracker WebSearchTracker
+			invalid := -1
+			for i, status := range tt.statuses {
+				err := tracker.Observe(&WebSearchCall{ID: "ws_1", Status: status})
+				if err != nil && invalid == -1 {
+					invalid = i
+					var protocolErr *WebSearchProtocolError
+					if !errors.As(err, &protocolErr) || protocolErr.ID != "ws_1" || protocolErr.To != status {
+						t.Errorf("Expected protocol error for ws_1 to %q, got %v", status, err)
+					}
+				}
+			}
+			if invalid != tt.invalid {
+				t.Errorf("Expected first violation at %d, got %d", tt.invalid, invalid)
+			}
+			if tt.invalid == -1 && len(tracker.Violations()) != 0 {
+				t.Errorf("Expected no violations, got %v", tracker.Violations())
+			}
+		})
+	}
+}
+
+func TestWebSearchTrackerKeepsTerminalStatus(t *testing.T) {
+	var tracker WebSearchTracker
+	tracker.Observe(&WebSearchCall{ID: "ws_1", Status: WebSearchStatusInProgress})
+	tracker.Observe(&WebSearchCall{ID: "ws_1", Status: WebSearchStatusCompleted})
+	tracker.Observe(&WebSearchCall{ID: "ws_1", Status: WebSearchStatusFailed})
+
+	if status, ok := tracker.Status("ws_1"); !ok || status != WebSearchStatusCompleted {
+		t.Errorf("Expected completed, got %q (%v)", status, ok)
+	}
+	if len(tracker.Violations()) != 1 {
+		t.Errorf("Expected 1 violation, got %d", len(tracker.Violations()))
+	}
+}
+
+func TestWebSearchTrackerMissingID(t *testing.T) {
+	var tracker WebSearchTracker
+	err := tracker.Observe(&WebSearchCall{Status: WebSearchStatusInProgress})
+	var protocolErr *WebSearchProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error, got %v", err)
+	}
+}
+
+func TestWebSearchTrackerFinish(t *testing.T) {
+	var tracker WebSearchTracker
+	tracker.Observe(&WebSearchCall{ID: "ws_1", Status: WebSearchStatusInProgress})
+	tracker.Observe(&WebSearchCall{ID: "ws_2", Status: WebSearchStatusInProgress})
+	tracker.Observe(&WebSearchCall{ID: "ws_2", Status: WebSearchStatusCompleted})
+
+	err := tracker.Finish()
+	var protocolErr *WebSearchProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error, got %v", err)
+	}
+	if protocolErr.ID != "ws_1" || protocolErr.From != WebSearchStatusInProgress || protocolErr.To != "" {
+		t.Errorf("Unexpected protocol error: %+v", protocolErr)
+	}
+}
+
+func TestWebSearchTrackerUnknownStatus(t *testing.T) {
+	var tracker WebSearchTracker
+	tracker.Observe(&WebSearchCall{ID: "ws_1", Status: "weird"})
+	if _, ok := tracker.Status("ws_1"); ok {
+		t.Errorf("Expected unknown status not to be recorded")
+	}
+	tracker.Observe(&WebSearchCall{ID: "ws_1", Status: WebSearchStatusInProgress})
+	tracker.Observe(&WebSearchCall{ID: "ws_1", Status: WebSearchStatusInProgress})
+	tracker.Finish()
+
+	// One violation for the unknown status, one for the unfinished search
+	if got := len(tracker.Violations()); got != 2 {
+		t.Errorf("Expected 2 violations, got %d: %v", got, tracker.Violations())
+	}
+}
+
+func TestWebSearchStreamTracker(t *testing.T) {
+	data := `data: {"type":"web_search_call","id":"ws_1","status":"completed","action":{"type":"search","query":"news"}}
+
+data: {"choices":[{"index":0,"delta":{"content":"Hello"}}]}
+
+data: [DONE]
+
+`
+
+	t.Run("lenient", func(t *testing.T) {
+		tracker := &WebSearchTracker{}
+		stream := NewWebSearchStreamWithOptions(io.NopCloser(strings.NewReader(data)), WebSearchStreamOptions{Tracker: tracker})
+		defer stream.Close()
+
+		events := 0
+		for stream.Next() {
+			events++
+		}
+		if err := stream.Err(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if events != 2 {
+			t.Errorf("Expected 2 events, got %d", events)
+		}
+		if len(tracker.Violations()) != 1 {
+			t.Errorf("Expected 1 violation, got %v", tracker.Violations())
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		tracker := &WebSearchTracker{Strict: true}
+		stream := NewWebSearchStreamWithOptions(io.NopCloser(strings.NewReader(data)), WebSearchStreamOptions{Tracker: tracker})
+		defer stream.Close()
+
+		if stream.Next() {
+			t.Fatal("Expected the stream to stop at the invalid event")
+		}
+		var protocolErr *WebSearchProtocolError
+		if !errors.As(stream.Err(), &protocolErr) {
+			t.Errorf("Expected protocol error, got %v", stream.Err())
+		}
+	})
+
+	t.Run("unfinished search", func(t *testing.T) {
+		tracker := &WebSearchTracker{Strict: true}
+		stream := NewWebSearchStreamWithOptions(io.NopCloser(strings.NewReader(`data: {"type":"web_search_call","id":"ws_1","status":"in_progress"}
+
+data: [DONE]
+
+`)), WebSearchStreamOptions{Tracker: tracker})
+		defer stream.Close()
+
+		for stream.Next() {
+		}
+		var protocolErr *WebSearchProtocolError
+		if !errors.As(stream.Err(), &protocolErr) || protocolErr.To != "" {
+			t.Errorf("Expected unfinished search error, got %v", stream.Err())
+		}
+	})
+
+	t.Run("unfinished search without done", func(t *testing.T) {
+		tracker := &WebSearchTracker{Strict: true}
+		stream := NewWebSearchStreamWithOptions(io.NopCloser(strings.NewReader(`data: {"type":"web_search_call","id":"ws_1","status":"in_progress"}
+
+`)), WebSearchStreamOptions{Tracker: tracker})
+		defer stream.Close()
+
+		for stream.Next() {
+		}
+		var protocolErr *WebSearchProtocolError
+		if !errors.As(stream.Err(), &protocolErr) || protocolErr.ID != "ws_1" {
+			t.Errorf("Expected unfinished search error at EOF, got %v", stream.Err())
+		}
+	})
+}
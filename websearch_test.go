@@ -1,7 +1,13 @@
 package tinfoil
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestWebSearchCallParsing(t *testing.T) {
@@ -265,3 +271,342 @@ data: [DONE]
 		t.Errorf("Expected 1 event (comments should be skipped), got %d", count)
 	}
 }
+
+func TestStreamMultiLineDataEvent(t *testing.T) {
+	sseData := "id: evt_1\r\n" +
+		"data:{\"choices\":[{\"index\":0,\r\n" +
+		"data: \"delta\":{\"content\":\"Hello\"}}]}\r\n" +
+		"\r\n" +
+		"data: [DONE]\r\n" +
+		"\r\n"
+
+	stream := SimulateWebSearchStream(sseData)
+	defer stream.Close()
+
+	if !stream.Next() {
+		t.Fatalf("Expected an event, got error: %v", stream.Err())
+	}
+	if content := stream.Current().Choices[0].Delta.Content; content != "Hello" {
+		t.Errorf("Expected content 'Hello', got '%s'", content)
+	}
+	if stream.LastEventID() != "evt_1" {
+		t.Errorf("Expected last event ID 'evt_1', got '%s'", stream.LastEventID())
+	}
+
+	if stream.Next() {
+		t.Error("Stream should end at [DONE]")
+	}
+	if stream.Err() != nil {
+		t.Errorf("Unexpected error: %v", stream.Err())
+	}
+}
+
+func TestStreamLargeEvent(t *testing.T) {
+	// Citation content well beyond bufio.Scanner's default 64 KiB line limit
+	content := strings.Repeat("a", 256*1024)
+	sseData := `data: {"choices":[{"index":0,"delta":{"annotations":[{"type":"url_citation","url_citation":{"title":"Big","url":"https://example.com","content":"` + content + `"}}]}}]}
+
+data: [DONE]
+
+`
+
+	stream := SimulateWebSearchStream(sseData)
+	defer stream.Close()
+
+	if !stream.Next() {
+		t.Fatalf("Expected an event, got error: %v", stream.Err())
+	}
+	if got := stream.Current().Choices[0].Delta.Annotations[0].URLCitation.Content; got != content {
+		t.Errorf("Citation content truncated to %d bytes", len(got))
+	}
+}
+
+func TestStreamMaxEventSize(t *testing.T) {
+	sseData := `data: {"choices":[{"index":0,"delta":{"content":"` + strings.Repeat("a", 1024) + `"}}]}
+
+data: [DONE]
+
+`
+
+	stream := NewWebSearchStreamWithOptions(io.NopCloser(strings.NewReader(sseData)), WebSearchStreamOptions{MaxEventSize: 512})
+	defer stream.Close()
+
+	if stream.Next() {
+		t.Fatal("Oversized event should not be returned")
+	}
+	if !errors.Is(stream.Err(), ErrEventTooLarge) {
+		t.Errorf("Expected ErrEventTooLarge, got %v", stream.Err())
+	}
+}
+
+func TestStreamContextCancellation(t *testing.T) {
+	body, writer := io.Pipe()
+	defer writer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := NewWebSearchStreamWithContext(ctx, body, WebSearchStreamOptions{})
+	defer stream.Close()
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	done := make(chan bool)
+	go func() { done <- stream.Next() }()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("Cancelled stream should not return an event")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Next did not return after cancellation")
+	}
+
+	var aborted *StreamAbortedError
+	if !errors.As(stream.Err(), &aborted) {
+		t.Fatalf("Expected StreamAbortedError, got %v", stream.Err())
+	}
+	if !errors.Is(stream.Err(), context.Canceled) {
+		t.Errorf("Expected context.Canceled cause, got %v", aborted.Cause)
+	}
+
+	// The body is closed, so the server side observes the abort
+	if _, err := writer.Write([]byte("data: {}\n\n")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Expected body to be closed, write returned %v", err)
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	body, writer := io.Pipe()
+	defer writer.Close()
+
+	stream := NewWebSearchStreamWithContext(context.Background(), body, WebSearchStreamOptions{
+		IdleTimeout: 50 * time.Millisecond,
+	})
+	defer stream.Close()
+
+	go writer.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\n"))
+
+	if !stream.Next() {
+		t.Fatalf("Expected first event, got error: %v", stream.Err())
+	}
+
+	// Time spent by the consumer between events does not count as idle
+	time.Sleep(100 * time.Millisecond)
+	go writer.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\" there\"}}]}\n\n"))
+	if !stream.Next() {
+		t.Fatalf("Expected second event, got error: %v", stream.Err())
+	}
+
+	// The enclave now stalls without sending anything
+	if stream.Next() {
+		t.Fatal("Stalled stream should not return an event")
+	}
+	if !errors.Is(stream.Err(), ErrStreamIdle) {
+		t.Errorf("Expected ErrStreamIdle, got %v", stream.Err())
+	}
+}
+
+func TestParseWebSearchMessages(t *testing.T) {
+	responseJSON := `{
+		"choices": [
+			{"index": 1, "finish_reason": "stop", "message": {"content": "Second", "search_reasoning": "b"}},
+			{"index": 0, "finish_reason": "stop", "message": {"content": "First", "annotations": [{"type": "url_citation", "url_citation": {"title": "A", "url": "https://a.example"}}]}}
+		]
+	}`
+
+	messages, err := ParseWebSearchMessages([]byte(responseJSON))
+	if err != nil {
+		t.Fatalf("Failed to parse messages: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(messages))
+	}
+	if messages[0].Content != "First" || len(messages[0].Annotations) != 1 {
+		t.Errorf("First message mismatch: %+v", messages[0])
+	}
+	if messages[1].Content != "Second" || messages[1].SearchReasoning != "b" {
+		t.Errorf("Second message mismatch: %+v", messages[1])
+	}
+
+	msg, err := ParseWebSearchMessage([]byte(responseJSON))
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if msg.Content != "Second" {
+		t.Errorf("Expected the first choice in the array, got %q", msg.Content)
+	}
+
+	// Only the plural APIs validate indices
+	for _, body := range []string{
+		`{"choices":[{"message":{"content":"x"}},{"message":{"content":"y"}}]}`,
+		`{"choices":[{"index":0,"message":{"content":"x"}},{"index":2,"message":{"content":"y"}}]}`,
+	} {
+		msg, err := ParseWebSearchMessage([]byte(body))
+		if err != nil || msg.Content != "x" {
+			t.Errorf("Expected %s to parse as its first choice, got %+v, %v", body, msg, err)
+		}
+		if _, err := ParseWebSearchResponse(strings.NewReader(body)); err != nil {
+			t.Errorf("Expected %s to parse, got %v", body, err)
+		}
+		if _, err := ParseWebSearchMessages([]byte(body)); err == nil {
+			t.Errorf("Expected invalid indices in %s to be rejected", body)
+		}
+	}
+
+	if _, err := ParseWebSearchMessages([]byte(`{"choices":[{"index":3,"message":{"content":"x"}}]}`)); err == nil {
+		t.Error("Expected an error for an out of range choice index")
+	}
+	if _, err := ParseWebSearchMessages([]byte(`{"choices":[{"index":0,"message":{"content":"x"}},{"message":{"content":"y"}}]}`)); err == nil {
+		t.Error("Expected an error for a duplicate choice index")
+	}
+
+	choices, err := ParseWebSearchChoices([]byte(responseJSON))
+	if err != nil {
+		t.Fatalf("Failed to parse choices: %v", err)
+	}
+	if choices[0].Index != 0 || choices[0].FinishReason != "stop" || choices[0].Message.Content != "First" {
+		t.Errorf("First choice mismatch: %+v", choices[0])
+	}
+}
+
+func TestParseWebSearchMessageToolCalls(t *testing.T) {
+	responseJSON := `{
+		"choices": [{
+			"index": 0,
+			"finish_reason": "tool_calls",
+			"message": {
+				"role": "assistant",
+				"content": null,
+				"refusal": null,
+				"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]
+			}
+		}]
+	}`
+
+	msg, err := ParseWebSearchMessage([]byte(responseJSON))
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	if msg.Role != "assistant" || msg.Content != "" || msg.Refusal != "" {
+		t.Errorf("Message mismatch: %+v", msg)
+	}
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0].ID != "call_1" || msg.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("Tool calls mismatch: %+v", msg.ToolCalls)
+	}
+}
+
+func TestStreamEventKinds(t *testing.T) {
+	sseData := `data: {"type":"web_search_call","id":"ws_1","status":"in_progress"}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Hi"}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"!"}}]}
+
+data: {"type":"web_search_results","id":"wsr_1","results":[{"url":"https://example.com"}]}
+
+data: {"object":"chat.completion.notice","message":"degraded"}
+
+data: {"hello":"world"}
+
+data: [DONE]
+
+`
+
+	stream := SimulateWebSearchStream(sseData)
+	defer stream.Close()
+
+	var kinds []WebSearchEventKind
+	var raws []string
+	for stream.Next() {
+		kinds = append(kinds, stream.Current().Kind())
+		raws = append(raws, string(stream.Current().Raw))
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+
+	want := []WebSearchEventKind{
+		WebSearchEventSearchCall,
+		WebSearchEventChunk,
+		WebSearchEventChunk,
+		"web_search_results",
+		"chat.completion.notice",
+		WebSearchEventUnknown,
+	}
+	if len(kinds) != len(want) {
+		t.Fatalf("Expected %d events, got %d", len(want), len(kinds))
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Errorf("Event %d: expected kind %q, got %q", i, want[i], kinds[i])
+		}
+		if kinds[i].IsKnown() != (i < 3) {
+			t.Errorf("Event %d: unexpected IsKnown for %q", i, kinds[i])
+		}
+	}
+	if raws[3] != `{"type":"web_search_results","id":"wsr_1","results":[{"url":"https://example.com"}]}` {
+		t.Errorf("Raw JSON mismatch: %s", raws[3])
+	}
+
+	// Unknown kinds are not mistaken for empty chunks
+	var acc WebSearchAccumulator
+	if acc.AddEvent(&WebSearchStreamEvent{Type: "web_search_results"}) {
+		t.Error("Expected an unknown event not to be accumulated")
+	}
+}
+
+func TestStreamRegisterDecoder(t *testing.T) {
+	type searchResults struct {
+		Results []struct {
+			URL string `json:"url"`
+		} `json:"results"`
+	}
+
+	sseData := `data: {"type":"web_search_results","id":"wsr_1","results":[{"url":"https://example.com"}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"Hi"}}]}
+
+data: [DONE]
+
+`
+
+	stream := SimulateWebSearchStream(sseData)
+	defer stream.Close()
+	stream.RegisterDecoder("web_search_results", func(raw json.RawMessage) (any, error) {
+		var results searchResults
+		err := json.Unmarshal(raw, &results)
+		return &results, err
+	})
+
+	if !stream.Next() {
+		t.Fatalf("Expected an event, got error: %v", stream.Err())
+	}
+	results, ok := stream.Current().Decoded.(*searchResults)
+	if !ok || len(results.Results) != 1 || results.Results[0].URL != "https://example.com" {
+		t.Errorf("Decoded mismatch: %#v", stream.Current().Decoded)
+	}
+
+	if !stream.Next() {
+		t.Fatalf("Expected an event, got error: %v", stream.Err())
+	}
+	if stream.Current().Decoded != nil {
+		t.Errorf("Expected no decoded value for a chunk, got %#v", stream.Current().Decoded)
+	}
+}
+
+func TestStreamRegisterDecoderError(t *testing.T) {
+	stream := SimulateWebSearchStream("data: {\"type\":\"custom\"}\n\n")
+	defer stream.Close()
+
+	decodeErr := errors.New("bad custom event")
+	stream.RegisterDecoder("custom", func(json.RawMessage) (any, error) {
+		return nil, decodeErr
+	})
+
+	if stream.Next() {
+		t.Fatal("Expected the decoder error to stop the stream")
+	}
+	if !errors.Is(stream.Err(), decodeErr) {
+		t.Errorf("Expected decoder error, got %v", stream.Err())
+	}
+}